@@ -0,0 +1,552 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// addressOwnerObjectType is the composite key object type mapping a Solana address to its user
+const addressOwnerObjectType = "addressOwner"
+
+// addressLinkObjectType is the composite key object type of the addresses linked to a user
+const addressLinkObjectType = "addressLink~user~address"
+
+// Address link statuses
+const (
+	AddressActive  = "ACTIVE"
+	AddressRevoked = "REVOKED"
+)
+
+// AddressLink records a Solana address linked to a user. The public KYC reference of a
+// user is stored under their primary address; the other active addresses resolve to it.
+type AddressLink struct {
+	Address          string `json:"address"`
+	UserID           string `json:"userId"`
+	Primary          bool   `json:"primary"`
+	Status           string `json:"status"`
+	LinkedAt         string `json:"linkedAt,omitempty"`
+	RevokedAt        string `json:"revokedAt,omitempty"`
+	RevocationReason string `json:"revocationReason,omitempty"`
+}
+
+// getAddressOwner returns the user an address is linked to, or an empty string
+func getAddressOwner(ctx contractapi.TransactionContextInterface, address string) (string, error) {
+	ownerKey, err := ctx.GetStub().CreateCompositeKey(addressOwnerObjectType, []string{address})
+	if err != nil {
+		return "", fmt.Errorf("failed to create address owner key: %v", err)
+	}
+
+	owner, err := ctx.GetStub().GetState(ownerKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read address owner: %v", err)
+	}
+
+	return string(owner), nil
+}
+
+// readAddressLink reads the link of an address to a user, or nil if the address is not linked to them
+func readAddressLink(ctx contractapi.TransactionContextInterface, userId string, address string) (*AddressLink, error) {
+	linkKey, err := ctx.GetStub().CreateCompositeKey(addressLinkObjectType, []string{userId, address})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create address link key: %v", err)
+	}
+
+	linkJSON, err := ctx.GetStub().GetState(linkKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address link: %v", err)
+	}
+	if linkJSON == nil {
+		return nil, nil
+	}
+
+	var link AddressLink
+	err = json.Unmarshal(linkJSON, &link)
+	if err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// getAddressLinks returns the addresses linked to a user
+func getAddressLinks(ctx contractapi.TransactionContextInterface, userId string) ([]*AddressLink, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(addressLinkObjectType, []string{userId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address links: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	links := []*AddressLink{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var link AddressLink
+		err = json.Unmarshal(queryResponse.Value, &link)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, &link)
+	}
+
+	return links, nil
+}
+
+// putAddressLink writes an address link and the owner mapping of its address
+func putAddressLink(ctx contractapi.TransactionContextInterface, link *AddressLink) error {
+	linkKey, err := ctx.GetStub().CreateCompositeKey(addressLinkObjectType, []string{link.UserID, link.Address})
+	if err != nil {
+		return fmt.Errorf("failed to create address link key: %v", err)
+	}
+
+	linkJSON, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(linkKey, linkJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put address link: %v", err)
+	}
+
+	ownerKey, err := ctx.GetStub().CreateCompositeKey(addressOwnerObjectType, []string{link.Address})
+	if err != nil {
+		return fmt.Errorf("failed to create address owner key: %v", err)
+	}
+
+	return ctx.GetStub().PutState(ownerKey, []byte(link.UserID))
+}
+
+// resolveKYCAddress returns the primary address holding the public KYC reference of the user
+// an address is linked to. Addresses stored before links existed resolve to themselves.
+func resolveKYCAddress(ctx contractapi.TransactionContextInterface, address string) (string, error) {
+	owner, err := getAddressOwner(ctx, address)
+	if err != nil || owner == "" {
+		return address, err
+	}
+
+	links, err := getAddressLinks(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+
+	primary := address
+	for _, link := range links {
+		if link.Address == address && link.Status == AddressRevoked {
+			return "", fmt.Errorf("address %s has been revoked", address)
+		}
+		if link.Primary {
+			primary = link.Address
+		}
+	}
+
+	return primary, nil
+}
+
+// addressBelongsToUser reports whether an address is already linked to a user, either
+// through an address link or as the address of a record stored before links existed
+func addressBelongsToUser(ctx contractapi.TransactionContextInterface, userId string, address string) (bool, error) {
+	owner, err := getAddressOwner(ctx, address)
+	if err != nil || owner != "" {
+		return owner == userId, err
+	}
+
+	publicRecord, err := readPublicKYCRecord(ctx, address)
+	if err != nil || publicRecord == nil {
+		return false, err
+	}
+
+	return publicRecord.UserID == userId, nil
+}
+
+// isAddressRevoked reports whether an address has been revoked by its user
+func isAddressRevoked(ctx contractapi.TransactionContextInterface, address string) (bool, error) {
+	owner, err := getAddressOwner(ctx, address)
+	if err != nil || owner == "" {
+		return false, err
+	}
+
+	link, err := readAddressLink(ctx, owner, address)
+	if err != nil || link == nil {
+		return false, err
+	}
+
+	return link.Status == AddressRevoked, nil
+}
+
+// linkedAddresses returns every address, active or revoked, of the user an address is linked to
+func linkedAddresses(ctx contractapi.TransactionContextInterface, address string) ([]string, error) {
+	owner, err := getAddressOwner(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if owner == "" {
+		return []string{address}, nil
+	}
+
+	links, err := getAddressLinks(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(links))
+	for _, link := range links {
+		addresses = append(addresses, link.Address)
+	}
+
+	return addresses, nil
+}
+
+// deletePublicKYCRecord removes the public KYC reference stored under an address and its
+// entries in the re-verification and country indexes
+func deletePublicKYCRecord(ctx contractapi.TransactionContextInterface, address string) error {
+	publicRecord, err := readPublicKYCRecord(ctx, address)
+	if err != nil || publicRecord == nil {
+		return err
+	}
+
+	if publicRecord.ReviewDate != "" {
+		reviewKey, err := ctx.GetStub().CreateCompositeKey(reviewIndexObjectType, []string{publicRecord.ReviewDate, address})
+		if err != nil {
+			return fmt.Errorf("failed to create review index key: %v", err)
+		}
+		err = ctx.GetStub().DelState(reviewKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete review index entry: %v", err)
+		}
+	}
+	if publicRecord.CountryCode != "" {
+		err = deleteCountryIndexEntry(ctx, publicRecord.CountryCode, address)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := kycKey(ctx, address)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete KYC status: %v", err)
+	}
+
+	return deleteLegacyKey(ctx, address)
+}
+
+// setPrimaryAddressLink makes an address the primary address of a user, linking it if needed.
+// The public KYC reference under the previous primary address is removed.
+func setPrimaryAddressLink(ctx contractapi.TransactionContextInterface, userId string, address string) error {
+	owner, err := getAddressOwner(ctx, address)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != userId {
+		return fmt.Errorf("address %s is linked to another user", address)
+	}
+
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	var current *AddressLink
+	for _, link := range links {
+		if link.Address == address {
+			current = link
+		}
+	}
+	if current != nil && current.Status == AddressRevoked {
+		return fmt.Errorf("address %s has been revoked", address)
+	}
+	if current != nil && current.Primary {
+		return nil
+	}
+
+	for _, link := range links {
+		if !link.Primary || link.Address == address {
+			continue
+		}
+		link.Primary = false
+		err = putAddressLink(ctx, link)
+		if err != nil {
+			return err
+		}
+		err = deletePublicKYCRecord(ctx, link.Address)
+		if err != nil {
+			return err
+		}
+	}
+
+	if current == nil {
+		now, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		current = &AddressLink{
+			Address:  address,
+			UserID:   userId,
+			Status:   AddressActive,
+			LinkedAt: now.Format(time.RFC3339),
+		}
+	}
+	current.Primary = true
+
+	return putAddressLink(ctx, current)
+}
+
+// checkPrimaryAddressUnchanged refuses a KYC record that would move the primary address of
+// a user already on the ledger. Only SetPrimaryAddress changes the primary address.
+func checkPrimaryAddressUnchanged(ctx contractapi.TransactionContextInterface, userId string, address string) error {
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	primaryAddress := ""
+	for _, link := range links {
+		if link.Primary {
+			primaryAddress = link.Address
+		}
+	}
+
+	// Records stored before addresses were linked keep their primary address in the record
+	if primaryAddress == "" {
+		existing, err := getPrivateKYCRecord(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			primaryAddress = existing.SolanaAddress
+		}
+	}
+
+	if primaryAddress != "" && primaryAddress != address {
+		return fmt.Errorf("user %s already has primary address %s, change it with SetPrimaryAddress", userId, primaryAddress)
+	}
+
+	return nil
+}
+
+// readPrivateKYCRecord reads a user's KYC record from the private collection, failing if there is none
+func readPrivateKYCRecord(ctx contractapi.TransactionContextInterface, userId string) (*KYCRecord, error) {
+	kycRecord, err := getPrivateKYCRecord(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if kycRecord == nil {
+		return nil, fmt.Errorf("no KYC record found for user %s", userId)
+	}
+
+	return kycRecord, nil
+}
+
+// LinkAddress links an additional Solana address to a user. The address resolves to the
+// user's KYC record until it is revoked. proofJSON is a WalletOwnershipProof signed by
+// the address over the challenge returned by GetWalletChallenge.
+func (s *SmartContract) LinkAddress(ctx contractapi.TransactionContextInterface,
+	userId string,
+	solanaAddress string,
+	proofJSON string) error {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	kycRecord, err := readPrivateKYCRecord(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	owner, err := getAddressOwner(ctx, solanaAddress)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		return fmt.Errorf("address %s is already linked", solanaAddress)
+	}
+	publicKey, err := kycKey(ctx, solanaAddress)
+	if err != nil {
+		return err
+	}
+	publicJSON, err := getStateWithLegacyKey(ctx, publicKey, solanaAddress)
+	if err != nil {
+		return fmt.Errorf("failed to read KYC status: %v", err)
+	}
+	if publicJSON != nil {
+		return fmt.Errorf("address %s already has a KYC record", solanaAddress)
+	}
+
+	proof, err := parseWalletOwnershipProof([]byte(proofJSON))
+	if err != nil {
+		return err
+	}
+	err = verifyWalletOwnership(ctx, userId, solanaAddress, proof)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Users stored before addresses were linked get their primary address linked first
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		err = putAddressLink(ctx, &AddressLink{
+			Address:  kycRecord.SolanaAddress,
+			UserID:   userId,
+			Primary:  true,
+			Status:   AddressActive,
+			LinkedAt: now.Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = putAddressLink(ctx, &AddressLink{
+		Address:  solanaAddress,
+		UserID:   userId,
+		Status:   AddressActive,
+		LinkedAt: now.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.RecordComplianceEvent(ctx, userId, "Address Linked", fmt.Sprintf("Address %s linked", solanaAddress))
+}
+
+// SetPrimaryAddress moves a user's public KYC reference to another of their active addresses
+func (s *SmartContract) SetPrimaryAddress(ctx contractapi.TransactionContextInterface,
+	userId string,
+	solanaAddress string) error {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	kycRecord, err := readPrivateKYCRecord(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if kycRecord.SolanaAddress == solanaAddress {
+		return fmt.Errorf("address %s is already the primary address", solanaAddress)
+	}
+
+	link, err := readAddressLink(ctx, userId, solanaAddress)
+	if err != nil {
+		return err
+	}
+	if link == nil || link.Status != AddressActive {
+		return fmt.Errorf("address %s is not an active address of user %s", solanaAddress, userId)
+	}
+
+	previousAddress := kycRecord.SolanaAddress
+	kycRecord.SolanaAddress = solanaAddress
+
+	err = s.putKYCRecord(ctx, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = emitKYCEvent(ctx, EventKYCStatusChanged, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	return s.RecordComplianceEvent(ctx, userId, "Primary Address Changed",
+		fmt.Sprintf("Primary address changed from %s to %s", previousAddress, solanaAddress))
+}
+
+// RevokeAddress revokes a compromised or retired address of a user. A primary address must
+// be replaced with SetPrimaryAddress before it can be revoked.
+func (s *SmartContract) RevokeAddress(ctx contractapi.TransactionContextInterface,
+	userId string,
+	solanaAddress string,
+	reason string) error {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	link, err := readAddressLink(ctx, userId, solanaAddress)
+	if err != nil {
+		return err
+	}
+	if link == nil {
+		return fmt.Errorf("address %s is not linked to user %s", solanaAddress, userId)
+	}
+	if link.Status == AddressRevoked {
+		return fmt.Errorf("address %s has already been revoked", solanaAddress)
+	}
+	if link.Primary {
+		return fmt.Errorf("address %s is the primary address, set another primary address first", solanaAddress)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	link.Status = AddressRevoked
+	link.RevokedAt = now.Format(time.RFC3339)
+	link.RevocationReason = reason
+
+	err = putAddressLink(ctx, link)
+	if err != nil {
+		return err
+	}
+
+	return s.RecordComplianceEvent(ctx, userId, "Address Revoked",
+		fmt.Sprintf("Address %s revoked: %s", solanaAddress, reason))
+}
+
+// GetUserByAddress returns the link of an address to its user
+func (s *SmartContract) GetUserByAddress(ctx contractapi.TransactionContextInterface,
+	solanaAddress string) (*AddressLink, error) {
+
+	owner, err := getAddressOwner(ctx, solanaAddress)
+	if err != nil {
+		return nil, err
+	}
+	if owner != "" {
+		return readAddressLink(ctx, owner, solanaAddress)
+	}
+
+	// Addresses stored before links existed are the primary address of their record
+	publicRecord, err := readPublicKYCRecord(ctx, solanaAddress)
+	if err != nil {
+		return nil, err
+	}
+	if publicRecord == nil {
+		return nil, fmt.Errorf("address %s is not linked to any user", solanaAddress)
+	}
+
+	return &AddressLink{
+		Address: solanaAddress,
+		UserID:  publicRecord.UserID,
+		Primary: true,
+		Status:  AddressActive,
+	}, nil
+}
+
+// GetUserAddresses returns the addresses linked to a user
+func (s *SmartContract) GetUserAddresses(ctx contractapi.TransactionContextInterface,
+	userId string) ([]*AddressLink, error) {
+
+	return getAddressLinks(ctx, userId)
+}
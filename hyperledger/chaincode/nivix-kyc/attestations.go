@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// attestationObjectType is the composite key object type of document attestations
+const attestationObjectType = "attestation~user~docType"
+
+// Document types that can be attested
+const (
+	DocPassport       = "PASSPORT"
+	DocNationalID     = "NATIONAL_ID"
+	DocDriversLicense = "DRIVERS_LICENSE"
+	DocSelfie         = "SELFIE"
+	DocProofOfAddress = "PROOF_OF_ADDRESS"
+)
+
+// documentTypes lists the document types that can be attested
+var documentTypes = map[string]bool{
+	DocPassport:       true,
+	DocNationalID:     true,
+	DocDriversLicense: true,
+	DocSelfie:         true,
+	DocProofOfAddress: true,
+}
+
+// documentHashPattern matches hex encoded SHA-256 digests
+var documentHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DocumentAttestation records that a verifier checked a document of a user. Only a salted
+// SHA-256 hash of the document is kept; the verifier keeps the salt and the document off-chain.
+type DocumentAttestation struct {
+	UserID         string `json:"userId"`
+	DocType        string `json:"docType"`
+	IssuingCountry string `json:"issuingCountry,omitempty"`
+	DocumentHash   string `json:"documentHash"`
+	VerifierOrg    string `json:"verifierOrg"`
+	Timestamp      string `json:"timestamp"`
+	TxID           string `json:"txId"`
+}
+
+// attestationKey builds the composite key of a document attestation
+func attestationKey(ctx contractapi.TransactionContextInterface, attestation *DocumentAttestation) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(attestationObjectType,
+		[]string{attestation.UserID, attestation.DocType, attestation.Timestamp, attestation.TxID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create attestation key: %v", err)
+	}
+
+	return key, nil
+}
+
+// parseAttestationInput decodes a document attestation from transient input and validates its fields
+func parseAttestationInput(attestationJSON []byte) (*DocumentAttestation, error) {
+	decoder := json.NewDecoder(bytes.NewReader(attestationJSON))
+	decoder.DisallowUnknownFields()
+
+	var attestation DocumentAttestation
+	err := decoder.Decode(&attestation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attestation JSON: %v", err)
+	}
+
+	if len(attestation.UserID) == 0 {
+		return nil, fmt.Errorf("userId field must be a non-empty string")
+	}
+	if !documentTypes[attestation.DocType] {
+		return nil, fmt.Errorf("docType field must be one of PASSPORT, NATIONAL_ID, DRIVERS_LICENSE, SELFIE or PROOF_OF_ADDRESS")
+	}
+	if attestation.IssuingCountry != "" && !countryCodePattern.MatchString(attestation.IssuingCountry) {
+		return nil, fmt.Errorf("issuingCountry field must be an ISO 3166-1 alpha-2 code")
+	}
+	attestation.DocumentHash = strings.ToLower(attestation.DocumentHash)
+	if !documentHashPattern.MatchString(attestation.DocumentHash) {
+		return nil, fmt.Errorf("documentHash field must be a hex encoded SHA-256 digest")
+	}
+	if attestation.VerifierOrg != "" || attestation.Timestamp != "" || attestation.TxID != "" {
+		return nil, fmt.Errorf("verifierOrg, timestamp and txId fields are set by the chaincode")
+	}
+
+	return &attestation, nil
+}
+
+// AttestDocument attaches a document attestation to a user's KYC record. The attestation
+// is read from the "document_attestation" key of the transient map, and the verifier
+// organization and timestamp are taken from the submitting transaction. Only the bridge
+// service and admins may attest documents.
+func (s *SmartContract) AttestDocument(ctx contractapi.TransactionContextInterface) error {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+
+	transientAttestationJSON, ok := transientMap["document_attestation"]
+	if !ok {
+		return fmt.Errorf("document_attestation not found in the transient map input")
+	}
+
+	attestation, err := parseAttestationInput(transientAttestationJSON)
+	if err != nil {
+		return err
+	}
+
+	kycBytes, err := ctx.GetStub().GetPrivateData("kycPrivateData", attestation.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to read KYC data: %v", err)
+	}
+	if kycBytes == nil {
+		return fmt.Errorf("no KYC record found for user %s", attestation.UserID)
+	}
+
+	verifierOrg, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	attestation.VerifierOrg = verifierOrg
+	attestation.Timestamp = now.Format(keyTimeLayout)
+	attestation.TxID = ctx.GetStub().GetTxID()
+
+	key, err := attestationKey(ctx, attestation)
+	if err != nil {
+		return err
+	}
+
+	// Attestations are append-only
+	existing, err := ctx.GetStub().GetPrivateData("kycPrivateData", key)
+	if err != nil {
+		return fmt.Errorf("failed to read attestation: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("attestation %s already exists", key)
+	}
+
+	attestationJSON, err := json.Marshal(attestation)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData("kycPrivateData", key, attestationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put attestation: %v", err)
+	}
+
+	return s.RecordComplianceEvent(ctx, attestation.UserID, "Document Attestation",
+		fmt.Sprintf("%s attested by %s", attestation.DocType, verifierOrg))
+}
+
+// getDocumentAttestations reads the attestations of a user, optionally of a single document type
+func getDocumentAttestations(ctx contractapi.TransactionContextInterface, userId string, docType string) ([]*DocumentAttestation, error) {
+	attributes := []string{userId}
+	if docType != "" {
+		attributes = append(attributes, docType)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey("kycPrivateData", attestationObjectType, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestations: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	attestations := []*DocumentAttestation{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var attestation DocumentAttestation
+		err = json.Unmarshal(queryResponse.Value, &attestation)
+		if err != nil {
+			return nil, err
+		}
+		attestations = append(attestations, &attestation)
+	}
+
+	return attestations, nil
+}
+
+// GetDocumentAttestations returns the document attestations of a user, oldest first per document type
+func (s *SmartContract) GetDocumentAttestations(ctx contractapi.TransactionContextInterface,
+	userId string) ([]*DocumentAttestation, error) {
+
+	return getDocumentAttestations(ctx, userId, "")
+}
+
+// VerifyDocumentAttestation returns the attestation proving that the document with the given
+// salted hash was checked for a user. It fails when no attestation of the document type matches.
+func (s *SmartContract) VerifyDocumentAttestation(ctx contractapi.TransactionContextInterface,
+	userId string,
+	docType string,
+	hash string) (*DocumentAttestation, error) {
+
+	if !documentTypes[docType] {
+		return nil, fmt.Errorf("unknown document type %s", docType)
+	}
+
+	attestations, err := getDocumentAttestations(ctx, userId, docType)
+	if err != nil {
+		return nil, err
+	}
+
+	hash = strings.ToLower(hash)
+	for _, attestation := range attestations {
+		if attestation.DocumentHash == hash {
+			return attestation, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no %s attestation of user %s matches the document hash", docType, userId)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestAttestDocumentRequiresBridge(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+	documentHash := strings.Repeat("ab", 32)
+
+	stub.MockTransactionStart("tx1")
+	putTestKYCRecord(t, stub.MockStub, &KYCRecord{UserID: "user123", SolanaAddress: "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE"})
+	err := stub.SetTransient(map[string][]byte{
+		"document_attestation": []byte(`{"userId":"user123","docType":"PASSPORT","documentHash":"` + documentHash + `"}`),
+	})
+	if err != nil {
+		t.Fatalf("failed to set transient map: %v", err)
+	}
+
+	for _, identity := range []*testClientIdentity{
+		{mspID: "Org1MSP", ou: []string{"client"}},
+		{mspID: "Org3MSP", attrs: map[string]string{"nivix.bridge": "true"}},
+	} {
+		ctx.SetClientIdentity(identity)
+		err = contract.AttestDocument(ctx)
+		if err == nil {
+			t.Errorf("AttestDocument succeeded for client %+v, want an authorization error", identity)
+		}
+	}
+	_, err = contract.VerifyDocumentAttestation(ctx, "user123", DocPassport, documentHash)
+	if err == nil {
+		t.Fatal("an unauthorized client's attestation verifies")
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", attrs: map[string]string{"nivix.bridge": "true"}})
+	err = contract.AttestDocument(ctx)
+	if err != nil {
+		t.Fatalf("bridge: AttestDocument failed: %v", err)
+	}
+	attestation, err := contract.VerifyDocumentAttestation(ctx, "user123", DocPassport, documentHash)
+	if err != nil {
+		t.Fatalf("VerifyDocumentAttestation failed: %v", err)
+	}
+	if attestation.VerifierOrg != "Org1MSP" {
+		t.Fatalf("verifierOrg = %s, want Org1MSP", attestation.VerifierOrg)
+	}
+}
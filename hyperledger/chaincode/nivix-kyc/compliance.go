@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// complianceEventObjectType is the composite key object type of compliance events
+const complianceEventObjectType = "complianceEvent"
+
+// complianceActionObjectType is the composite key object type of the compliance event index by action
+const complianceActionObjectType = "complianceEvent~action"
+
+// keyTimeLayout is a fixed width UTC layout, so that timestamps in composite
+// keys sort chronologically
+const keyTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// ComplianceQueryResult structure used for returning paginated compliance query results
+type ComplianceQueryResult struct {
+	Records             []*ComplianceRecord `json:"records"`
+	FetchedRecordsCount int32               `json:"fetchedRecordsCount"`
+	Bookmark            string              `json:"bookmark"`
+}
+
+// complianceEventKey builds the composite key of a compliance event, or of its index entry
+// when objectType is complianceActionObjectType and prefix is the action
+func complianceEventKey(ctx contractapi.TransactionContextInterface, objectType string, prefix string, record *ComplianceRecord, timestamp time.Time) (string, error) {
+	complianceKey, err := ctx.GetStub().CreateCompositeKey(objectType, []string{
+		prefix,
+		timestamp.UTC().Format(keyTimeLayout),
+		record.TxID,
+		fmt.Sprintf("%04d", record.Sequence),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create compliance event key: %v", err)
+	}
+
+	return complianceKey, nil
+}
+
+// GetComplianceEvents returns the compliance events of a user recorded between fromTime
+// and toTime (RFC3339, either may be empty for an open range), oldest first.
+// An empty action returns events of every action. Pass the bookmark of the
+// previous page to continue where it ended.
+func (s *SmartContract) GetComplianceEvents(ctx contractapi.TransactionContextInterface,
+	userId string,
+	fromTime string,
+	toTime string,
+	action string,
+	pageSize int32,
+	bookmark string) (*ComplianceQueryResult, error) {
+
+	if len(userId) == 0 {
+		return nil, fmt.Errorf("userId must be a non-empty string")
+	}
+
+	return queryComplianceEvents(ctx, complianceEventObjectType, userId, fromTime, toTime, action, pageSize, bookmark)
+}
+
+// GetComplianceEventsByAction returns the compliance events of all users for an action
+// recorded between fromTime and toTime, oldest first, using the action index
+func (s *SmartContract) GetComplianceEventsByAction(ctx contractapi.TransactionContextInterface,
+	action string,
+	fromTime string,
+	toTime string,
+	pageSize int32,
+	bookmark string) (*ComplianceQueryResult, error) {
+
+	if len(action) == 0 {
+		return nil, fmt.Errorf("action must be a non-empty string")
+	}
+
+	return queryComplianceEvents(ctx, complianceActionObjectType, action, fromTime, toTime, "", pageSize, bookmark)
+}
+
+// complianceEventsFrom returns the compliance events stored under objectType and prefix in
+// key order, starting at startKey. GetPrivateDataByRange does not accept composite keys, so
+// on CouchDB the events are read with a rich query on the _id range beginning at startKey;
+// LevelDB has no such query, so there the caller skips the keys before startKey.
+func complianceEventsFrom(ctx contractapi.TransactionContextInterface,
+	objectType string,
+	prefix string,
+	startKey string) (shim.StateQueryIteratorInterface, error) {
+
+	firstKey, err := ctx.GetStub().CreateCompositeKey(objectType, []string{prefix})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compliance event key: %v", err)
+	}
+	if startKey < firstKey {
+		startKey = firstKey
+	}
+
+	queryString, err := json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{
+			"_id": map[string]interface{}{
+				"$gte": startKey,
+				"$lt":  firstKey + string(utf8.MaxRune),
+			},
+		},
+		"sort": []map[string]string{{"_id": "asc"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataQueryResult("complianceRecords", string(queryString))
+	if err == nil {
+		return resultsIterator, nil
+	}
+	if !strings.Contains(strings.ToLower(err.Error()), "leveldb") {
+		return nil, fmt.Errorf("failed to run rich query: %v", err)
+	}
+
+	return ctx.GetStub().GetPrivateDataByPartialCompositeKey("complianceRecords", objectType, []string{prefix})
+}
+
+// queryComplianceEvents pages through the compliance events stored under objectType and prefix.
+// Private data offers no paginated queries, so the bookmark is the encoded key of the first
+// event of the next page, and reading starts at that key or at the key of fromTime,
+// whichever is later.
+func queryComplianceEvents(ctx contractapi.TransactionContextInterface,
+	objectType string,
+	prefix string,
+	fromTime string,
+	toTime string,
+	action string,
+	pageSize int32,
+	bookmark string) (*ComplianceQueryResult, error) {
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	from, err := parseTimeBound(fromTime, "fromTime")
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeBound(toTime, "toTime")
+	if err != nil {
+		return nil, err
+	}
+
+	startKey := ""
+	if bookmark != "" {
+		startKeyBytes, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark: %v", err)
+		}
+		startKey = string(startKeyBytes)
+	}
+	if !from.IsZero() {
+		fromKey, err := ctx.GetStub().CreateCompositeKey(objectType, []string{prefix, from.Format(keyTimeLayout)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create compliance event key: %v", err)
+		}
+		if fromKey > startKey {
+			startKey = fromKey
+		}
+	}
+
+	resultsIterator, err := complianceEventsFrom(ctx, objectType, prefix, startKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &ComplianceQueryResult{
+		Records: []*ComplianceRecord{},
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if queryResponse.Key < startKey {
+			continue
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := time.Parse(keyTimeLayout, keyParts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in compliance key: %v", err)
+		}
+		if !from.IsZero() && timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && timestamp.After(to) {
+			// Keys are ordered by timestamp, nothing later can match
+			break
+		}
+
+		complianceJSON := queryResponse.Value
+		if objectType == complianceActionObjectType {
+			complianceJSON, err = ctx.GetStub().GetPrivateData("complianceRecords", string(queryResponse.Value))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read compliance record: %v", err)
+			}
+			if complianceJSON == nil {
+				continue
+			}
+		}
+
+		var complianceRecord ComplianceRecord
+		err = json.Unmarshal(complianceJSON, &complianceRecord)
+		if err != nil {
+			return nil, err
+		}
+		if action != "" && complianceRecord.Action != action {
+			continue
+		}
+
+		if len(result.Records) == int(pageSize) {
+			result.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(queryResponse.Key))
+			break
+		}
+		result.Records = append(result.Records, &complianceRecord)
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+
+	return result, nil
+}
+
+// parseTimeBound parses an optional RFC3339 query bound, returning the zero time when empty
+func parseTimeBound(value string, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	bound, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+	}
+
+	return bound.UTC(), nil
+}
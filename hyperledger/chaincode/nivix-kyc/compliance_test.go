@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// newComplianceTestContext records a compliance event of user123 every minute from
+// 2025-06-01T00:00:00Z, alternating the actions "A" and "B", and one event of user1234
+func newComplianceTestContext(t *testing.T, count int) *TransactionContext {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= count; i++ {
+		stub.MockTransactionStart(fmt.Sprintf("tx%d", i))
+		stub.TxTimestamp = &timestamp.Timestamp{Seconds: start.Add(time.Duration(i) * time.Minute).Unix()}
+		userId, action := "user123", []string{"A", "B"}[i%2]
+		if i == count {
+			userId = "user1234"
+		}
+		err := contract.RecordComplianceEvent(ctx, userId, action, fmt.Sprintf("event %d", i))
+		if err != nil {
+			t.Fatalf("RecordComplianceEvent failed: %v", err)
+		}
+	}
+
+	return ctx
+}
+
+// complianceTestDescriptions reads every page of a compliance query and joins the descriptions
+func complianceTestDescriptions(t *testing.T, query func(bookmark string) (*ComplianceQueryResult, error)) string {
+	descriptions := []string{}
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("compliance query did not finish")
+		}
+		result, err := query(bookmark)
+		if err != nil {
+			t.Fatalf("compliance query failed: %v", err)
+		}
+		if result.FetchedRecordsCount != int32(len(result.Records)) {
+			t.Fatalf("fetchedRecordsCount %d with %d records", result.FetchedRecordsCount, len(result.Records))
+		}
+		for _, record := range result.Records {
+			descriptions = append(descriptions, record.Description)
+		}
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+func TestGetComplianceEventsPages(t *testing.T) {
+	ctx := newComplianceTestContext(t, 5)
+	contract := new(SmartContract)
+
+	tests := []struct {
+		name     string
+		fromTime string
+		toTime   string
+		action   string
+		want     string
+	}{
+		{"every event", "", "", "", "event 0, event 1, event 2, event 3, event 4"},
+		{"from a time", "2025-06-01T00:02:00Z", "", "", "event 2, event 3, event 4"},
+		{"time range", "2025-06-01T00:01:00Z", "2025-06-01T00:03:00Z", "", "event 1, event 2, event 3"},
+		{"action", "", "", "B", "event 1, event 3"},
+		{"action from a time", "2025-06-01T00:02:00Z", "", "A", "event 2, event 4"},
+	}
+	for _, test := range tests {
+		got := complianceTestDescriptions(t, func(bookmark string) (*ComplianceQueryResult, error) {
+			return contract.GetComplianceEvents(ctx, "user123", test.fromTime, test.toTime, test.action, 2, bookmark)
+		})
+		if got != test.want {
+			t.Errorf("%s: GetComplianceEvents = %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	got := complianceTestDescriptions(t, func(bookmark string) (*ComplianceQueryResult, error) {
+		return contract.GetComplianceEventsByAction(ctx, "A", "2025-06-01T00:01:00Z", "", 1, bookmark)
+	})
+	if got != "event 2, event 4" {
+		t.Errorf("GetComplianceEventsByAction = %s, want event 2, event 4", got)
+	}
+
+	_, err := contract.GetComplianceEvents(ctx, "user123", "", "", "", 0, "")
+	if err == nil {
+		t.Error("GetComplianceEvents accepted a zero pageSize")
+	}
+	_, err = contract.GetComplianceEvents(ctx, "user123", "", "", "", 2, "not a bookmark!")
+	if err == nil {
+		t.Error("GetComplianceEvents accepted an invalid bookmark")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// configObjectType is the composite key object type for chaincode configuration entries
+const configObjectType = "config"
+
+// kycAPIVersionConfig holds the KYC API version enforced by the chaincode
+const kycAPIVersionConfig = "kycApiVersion"
+
+// adminMSPIDsConfig holds the MSP IDs whose clients may administer the chaincode
+const adminMSPIDsConfig = "adminMspIds"
+
+// defaultAdminMSPIDs are the members of the KYC collections, which administer the
+// chaincode until an admin configures the admin MSPs
+var defaultAdminMSPIDs = []string{"Org1MSP", "Org2MSP"}
+
+// bridgeMSPIDsConfig holds the MSP IDs whose clients may act for the bridge service
+const bridgeMSPIDsConfig = "bridgeMspIds"
+
+// defaultBridgeMSPIDs are the members of the KYC collections, which run the bridge
+// service until an admin configures the bridge MSPs
+var defaultBridgeMSPIDs = []string{"Org1MSP", "Org2MSP"}
+
+// getConfig reads a configuration entry into value and reports whether it was found
+func getConfig(ctx contractapi.TransactionContextInterface, name string, value interface{}) (bool, error) {
+	configKey, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return false, fmt.Errorf("failed to create config key: %v", err)
+	}
+
+	configJSON, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config %s: %v", name, err)
+	}
+	if configJSON == nil {
+		return false, nil
+	}
+
+	err = json.Unmarshal(configJSON, value)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal config %s: %v", name, err)
+	}
+
+	return true, nil
+}
+
+// putConfig stores a configuration entry
+func putConfig(ctx contractapi.TransactionContextInterface, name string, value interface{}) error {
+	configKey, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return fmt.Errorf("failed to create config key: %v", err)
+	}
+
+	configJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(configKey, configJSON)
+}
+
+// getAdminMSPIDs reads the MSP IDs whose clients may administer the chaincode
+func getAdminMSPIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	mspIDs := []string{}
+	found, err := getConfig(ctx, adminMSPIDsConfig, &mspIDs)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		mspIDs = append(mspIDs, defaultAdminMSPIDs...)
+	}
+
+	return mspIDs, nil
+}
+
+// getBridgeMSPIDs reads the MSP IDs whose clients may act for the bridge service
+func getBridgeMSPIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	mspIDs := []string{}
+	found, err := getConfig(ctx, bridgeMSPIDsConfig, &mspIDs)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		mspIDs = append(mspIDs, defaultBridgeMSPIDs...)
+	}
+
+	return mspIDs, nil
+}
+
+// clientMSPIn reports whether the submitting client belongs to one of the given MSPs
+func clientMSPIn(ctx contractapi.TransactionContextInterface, mspIDs []string) (bool, error) {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return false, fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	for _, mspID := range mspIDs {
+		if mspID == clientMSPID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// assertAdmin checks that the submitting client may change chaincode configuration.
+// Administrators belong to one of the admin MSPs and either carry the nivix.admin=true
+// certificate attribute or were enrolled with the admin OU by their organization's CA.
+func assertAdmin(ctx contractapi.TransactionContextInterface) error {
+	adminMSPIDs, err := getAdminMSPIDs(ctx)
+	if err != nil {
+		return err
+	}
+	adminMSP, err := clientMSPIn(ctx, adminMSPIDs)
+	if err != nil {
+		return err
+	}
+	if !adminMSP {
+		return fmt.Errorf("submitting client is not authorized to perform administrative functions")
+	}
+
+	value, found, err := ctx.GetClientIdentity().GetAttributeValue("nivix.admin")
+	if err != nil {
+		return fmt.Errorf("failed to read client attributes: %v", err)
+	}
+	if found && value == "true" {
+		return nil
+	}
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to read client certificate: %v", err)
+	}
+	if cert != nil {
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if ou == "admin" {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("submitting client is not authorized to perform administrative functions")
+}
+
+// assertBridge checks that the submitting client may act on users' wallets and transfers.
+// The bridge service belongs to one of the bridge MSPs and carries the nivix.bridge=true
+// certificate attribute; administrators are accepted as well.
+func assertBridge(ctx contractapi.TransactionContextInterface) error {
+	bridgeMSPIDs, err := getBridgeMSPIDs(ctx)
+	if err != nil {
+		return err
+	}
+	bridgeMSP, err := clientMSPIn(ctx, bridgeMSPIDs)
+	if err != nil {
+		return err
+	}
+	value, found, err := ctx.GetClientIdentity().GetAttributeValue("nivix.bridge")
+	if err != nil {
+		return fmt.Errorf("failed to read client attributes: %v", err)
+	}
+	if bridgeMSP && found && value == "true" {
+		return nil
+	}
+
+	if assertAdmin(ctx) != nil {
+		return fmt.Errorf("submitting client is not authorized to act for the bridge")
+	}
+
+	return nil
+}
+
+// getKYCAPIVersion returns the KYC API version in force, defaulting to 1
+func getKYCAPIVersion(ctx contractapi.TransactionContextInterface) (int, error) {
+	version := 1
+	_, err := getConfig(ctx, kycAPIVersionConfig, &version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// SetKYCAPIVersion sets the KYC API version enforced by the chaincode.
+// From version 2 onwards personal data is only accepted through StoreKYCPrivate.
+func (s *SmartContract) SetKYCAPIVersion(ctx contractapi.TransactionContextInterface,
+	version int) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version < 1 || version > currentKYCAPIVersion {
+		return fmt.Errorf("KYC API version must be between 1 and %d", currentKYCAPIVersion)
+	}
+
+	return putConfig(ctx, kycAPIVersionConfig, version)
+}
+
+// GetKYCAPIVersion returns the KYC API version enforced by the chaincode
+func (s *SmartContract) GetKYCAPIVersion(ctx contractapi.TransactionContextInterface) (int, error) {
+	return getKYCAPIVersion(ctx)
+}
+
+// SetAdminMSPIDs sets the MSP IDs whose clients may administer the chaincode. The
+// submitting client's own MSP must stay in the list.
+func (s *SmartContract) SetAdminMSPIDs(ctx contractapi.TransactionContextInterface,
+	mspIDs []string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	includesClient := false
+	for _, mspID := range mspIDs {
+		if len(mspID) == 0 {
+			return fmt.Errorf("admin MSP IDs must be non-empty strings")
+		}
+		if mspID == clientMSPID {
+			includesClient = true
+		}
+	}
+	if !includesClient {
+		return fmt.Errorf("admin MSP IDs must include the client's own MSP %s", clientMSPID)
+	}
+
+	return putConfig(ctx, adminMSPIDsConfig, mspIDs)
+}
+
+// GetAdminMSPIDs returns the MSP IDs whose clients may administer the chaincode
+func (s *SmartContract) GetAdminMSPIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getAdminMSPIDs(ctx)
+}
+
+// SetBridgeMSPIDs sets the MSP IDs whose clients may act for the bridge service.
+// Clients of other MSPs are refused even if their certificate carries nivix.bridge=true.
+func (s *SmartContract) SetBridgeMSPIDs(ctx contractapi.TransactionContextInterface,
+	mspIDs []string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mspID := range mspIDs {
+		if len(mspID) == 0 {
+			return fmt.Errorf("bridge MSP IDs must be non-empty strings")
+		}
+	}
+
+	return putConfig(ctx, bridgeMSPIDsConfig, mspIDs)
+}
+
+// GetBridgeMSPIDs returns the MSP IDs whose clients may act for the bridge service
+func (s *SmartContract) GetBridgeMSPIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getBridgeMSPIDs(ctx)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestAssertBridgeRequiresBridgeMSP(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+	bridgeAttrs := map[string]string{"nivix.bridge": "true"}
+
+	stub.MockTransactionStart("tx1")
+	tests := []struct {
+		name       string
+		identity   *testClientIdentity
+		authorized bool
+	}{
+		{"bridge of a bridge MSP", &testClientIdentity{mspID: "Org1MSP", attrs: bridgeAttrs}, true},
+		{"bridge attribute of a partner MSP", &testClientIdentity{mspID: "Org3MSP", attrs: bridgeAttrs}, false},
+		{"client without the bridge attribute", &testClientIdentity{mspID: "Org1MSP"}, false},
+		{"admin", &testClientIdentity{mspID: "Org2MSP", ou: []string{"admin"}}, true},
+	}
+	for _, test := range tests {
+		ctx.SetClientIdentity(test.identity)
+		err := assertBridge(ctx)
+		if test.authorized && err != nil {
+			t.Errorf("%s: assertBridge failed: %v", test.name, err)
+		}
+		if !test.authorized && err == nil {
+			t.Errorf("%s: assertBridge succeeded, want an authorization error", test.name)
+		}
+	}
+
+	// Once Org2MSP alone runs the bridge, Org1MSP's bridge attribute is ignored
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	err := contract.SetBridgeMSPIDs(ctx, []string{"Org2MSP"})
+	if err != nil {
+		t.Fatalf("SetBridgeMSPIDs failed: %v", err)
+	}
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", attrs: bridgeAttrs})
+	if assertBridge(ctx) == nil {
+		t.Error("assertBridge accepted a bridge client of an MSP removed from the bridge MSPs")
+	}
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org2MSP", attrs: bridgeAttrs})
+	if err := assertBridge(ctx); err != nil {
+		t.Errorf("assertBridge refused a bridge client of the configured bridge MSP: %v", err)
+	}
+}
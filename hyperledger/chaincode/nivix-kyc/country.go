@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// countryIndexObjectType is the composite key object type of the KYC index by country
+const countryIndexObjectType = "country~address"
+
+// KYCQueryResult structure used for returning paginated KYC query results
+type KYCQueryResult struct {
+	Records             []*KYCRecord `json:"records"`
+	FetchedRecordsCount int32        `json:"fetchedRecordsCount"`
+	Bookmark            string       `json:"bookmark"`
+}
+
+// IndexBackfillResult reports a batch of an index backfill
+type IndexBackfillResult struct {
+	Scanned  int    `json:"scanned"`
+	Indexed  int    `json:"indexed"`
+	Bookmark string `json:"bookmark"`
+}
+
+// countryIndexKey builds the composite key of a KYC record's country index entry
+func countryIndexKey(ctx contractapi.TransactionContextInterface, countryCode string, address string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(countryIndexObjectType, []string{countryCode, address})
+	if err != nil {
+		return "", fmt.Errorf("failed to create country index key: %v", err)
+	}
+
+	return key, nil
+}
+
+// putCountryIndexEntry adds a public KYC reference to the country index
+func putCountryIndexEntry(ctx contractapi.TransactionContextInterface, countryCode string, address string) error {
+	key, err := countryIndexKey(ctx, countryCode, address)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("failed to put country index entry: %v", err)
+	}
+
+	return nil
+}
+
+// deleteCountryIndexEntry removes a public KYC reference from the country index
+func deleteCountryIndexEntry(ctx contractapi.TransactionContextInterface, countryCode string, address string) error {
+	key, err := countryIndexKey(ctx, countryCode, address)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete country index entry: %v", err)
+	}
+
+	return nil
+}
+
+// BackfillCountryIndex adds public KYC references stored before the country index existed
+// to the index. Each call scans up to pageSize public KYC references and returns a bookmark
+// to pass to the next call until it is empty. References still stored under simple keys
+// are only found once MigrateKeyspace has moved them.
+func (s *SmartContract) BackfillCountryIndex(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	bookmark string) (*IndexBackfillResult, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	startKey := ""
+	if bookmark != "" {
+		startKeyBytes, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark: %v", err)
+		}
+		startKey = string(startKeyBytes)
+	}
+
+	entries, nextKey, err := scanObjectType(ctx, kycObjectType, startKey, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IndexBackfillResult{
+		Scanned: len(entries),
+	}
+	if nextKey != "" {
+		result.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(nextKey))
+	}
+	for _, entry := range entries {
+		publicRecord, err := decodePublicKYCRecord(entry.Value)
+		if err != nil || publicRecord.CountryCode == "" || publicRecord.Erased {
+			continue
+		}
+
+		err = putCountryIndexEntry(ctx, publicRecord.CountryCode, publicRecord.SolanaAddress)
+		if err != nil {
+			return nil, err
+		}
+		result.Indexed++
+	}
+
+	return result, nil
+}
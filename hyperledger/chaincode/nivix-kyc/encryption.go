@@ -0,0 +1,423 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// orgKeyTransientField is the transient map key carrying the 32-byte AES-256 org key
+const orgKeyTransientField = "kyc_org_key"
+
+// dataKeyTransientField is the transient map key carrying 32 random bytes for a new data key
+const dataKeyTransientField = "kyc_data_key"
+
+// dataKeyObjectType is the composite key object type of wrapped per-user data keys
+const dataKeyObjectType = "dataKey~user"
+
+// dataKeyCollection holds the wrapped data keys apart from the encrypted records, so that
+// a copy of kycPrivateData cannot be decrypted with the org key alone
+const dataKeyCollection = "kycDataKeys"
+
+// legacyDataKeyCollection held the wrapped data keys next to the records. Keys found there
+// are moved to dataKeyCollection on the user's next encrypted write.
+const legacyDataKeyCollection = "kycPrivateData"
+
+// kycEncryptionAlgorithm names the cipher used for data keys and KYC records
+const kycEncryptionAlgorithm = "AES-256-GCM"
+
+// WrappedDataKey is a user's data key encrypted with the org key, stored in the kycDataKeys
+// collection. Purging it makes every copy of the user's encrypted KYC record unreadable,
+// including archived private data and backups.
+type WrappedDataKey struct {
+	UserID     string `json:"userId"`
+	OrgKeyID   string `json:"orgKeyId"`
+	Algorithm  string `json:"algorithm"`
+	Nonce      string `json:"nonce"`
+	WrappedKey string `json:"wrappedKey"`
+	CreatedAt  string `json:"createdAt"`
+	TxID       string `json:"txId"`
+}
+
+// encryptedKYCRecord is the envelope stored in kycPrivateData for an encrypted KYC record
+type encryptedKYCRecord struct {
+	Encryption string `json:"encryption"`
+	OrgKeyID   string `json:"orgKeyId"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// getOrgKey reads the org key from the transient map. It returns nil when no key was supplied.
+func getOrgKey(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+
+	orgKey, ok := transientMap[orgKeyTransientField]
+	if !ok {
+		return nil, nil
+	}
+	if len(orgKey) != 32 {
+		return nil, fmt.Errorf("%s must be a 32-byte AES-256 key", orgKeyTransientField)
+	}
+
+	return orgKey, nil
+}
+
+// orgKeyID identifies an org key without revealing it
+func orgKeyID(orgKey []byte) string {
+	hash := sha256.Sum256(orgKey)
+	return hex.EncodeToString(hash[:8])
+}
+
+// deriveBytes computes HMAC-SHA256(key, parts joined by NUL bytes). Endorsing peers must
+// produce identical write sets, so nonces are derived from the key and the transaction
+// instead of being drawn at random.
+func deriveBytes(key []byte, parts ...string) []byte {
+	mac := hmac.New(sha256.New, key)
+	for i, part := range parts {
+		if i > 0 {
+			mac.Write([]byte{0})
+		}
+		mac.Write([]byte(part))
+	}
+
+	return mac.Sum(nil)
+}
+
+// sealAESGCM encrypts plaintext with AES-256-GCM, binding it to the additional data
+func sealAESGCM(key []byte, nonce []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nil, nonce[:aead.NonceSize()], plaintext, additionalData), nil
+}
+
+// openAESGCM decrypts and authenticates an AES-256-GCM ciphertext
+func openAESGCM(key []byte, nonce []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length")
+	}
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// dataKeyKey builds the composite key of a user's wrapped data key
+func dataKeyKey(ctx contractapi.TransactionContextInterface, userId string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(dataKeyObjectType, []string{userId})
+	if err != nil {
+		return "", fmt.Errorf("failed to create data key key: %v", err)
+	}
+
+	return key, nil
+}
+
+// getWrappedDataKeyBytes reads a user's wrapped data key and the collection holding it,
+// or nil if the user has none
+func getWrappedDataKeyBytes(ctx contractapi.TransactionContextInterface, userId string) ([]byte, string, error) {
+	key, err := dataKeyKey(ctx, userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, collection := range []string{dataKeyCollection, legacyDataKeyCollection} {
+		wrappedBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read data key: %v", err)
+		}
+		if wrappedBytes != nil {
+			return wrappedBytes, collection, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// getWrappedDataKey reads a user's wrapped data key, or nil if the user has none
+func getWrappedDataKey(ctx contractapi.TransactionContextInterface, userId string) (*WrappedDataKey, error) {
+	wrappedBytes, _, err := getWrappedDataKeyBytes(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if wrappedBytes == nil {
+		return nil, nil
+	}
+
+	var wrapped WrappedDataKey
+	err = json.Unmarshal(wrappedBytes, &wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrapped, nil
+}
+
+// unwrapDataKey decrypts a user's data key with the org key
+func unwrapDataKey(wrapped *WrappedDataKey, orgKey []byte) ([]byte, error) {
+	if wrapped.OrgKeyID != orgKeyID(orgKey) {
+		return nil, fmt.Errorf("data key of user %s is wrapped by org key %s", wrapped.UserID, wrapped.OrgKeyID)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(wrapped.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key nonce: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(wrapped.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %v", err)
+	}
+	dataKey, err := openAESGCM(orgKey, nonce, ciphertext, []byte(wrapped.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of user %s: %v", wrapped.UserID, err)
+	}
+
+	return dataKey, nil
+}
+
+// createDataKey stores a new data key for a user, wrapped by the org key. The key is the
+// random key material the client passes in the transient map, so that nothing recorded on
+// the ledger allows it to be recomputed once it is purged.
+func createDataKey(ctx contractapi.TransactionContextInterface, userId string, orgKey []byte) ([]byte, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	dataKey, ok := transientMap[dataKeyTransientField]
+	if !ok {
+		return nil, fmt.Errorf("%s is required in the transient map to create the data key of user %s", dataKeyTransientField, userId)
+	}
+	if len(dataKey) != 32 {
+		return nil, fmt.Errorf("%s must be 32 random bytes", dataKeyTransientField)
+	}
+	if hmac.Equal(dataKey, orgKey) {
+		return nil, fmt.Errorf("%s must not be the org key", dataKeyTransientField)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	nonce := deriveBytes(orgKey, "data key nonce", userId, txID)[:12]
+
+	wrappedKey, err := sealAESGCM(orgKey, nonce, dataKey, []byte(userId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := &WrappedDataKey{
+		UserID:     userId,
+		OrgKeyID:   orgKeyID(orgKey),
+		Algorithm:  kycEncryptionAlgorithm,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		CreatedAt:  now.Format(time.RFC3339),
+		TxID:       txID,
+	}
+	wrappedJSON, err := json.Marshal(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := dataKeyKey(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutPrivateData(dataKeyCollection, key, wrappedJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put data key: %v", err)
+	}
+
+	return dataKey, nil
+}
+
+// moveLegacyDataKey moves a user's wrapped data key out of kycPrivateData into its own
+// collection, purging the copy kept next to the record
+func moveLegacyDataKey(ctx contractapi.TransactionContextInterface, userId string) error {
+	wrappedBytes, collection, err := getWrappedDataKeyBytes(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if collection != legacyDataKeyCollection {
+		return nil
+	}
+
+	key, err := dataKeyKey(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(dataKeyCollection, key, wrappedBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put data key: %v", err)
+	}
+	err = ctx.GetStub().PurgePrivateData(legacyDataKeyCollection, key)
+	if err != nil {
+		return fmt.Errorf("failed to purge data key: %v", err)
+	}
+
+	return nil
+}
+
+// encodeKYCRecord serializes a KYC record for the private collection. The record is
+// encrypted with the user's data key when the user has one or the org key is supplied;
+// a user's first encrypted write creates the data key. Once a user has a data key, the
+// record is never written in plaintext again.
+func encodeKYCRecord(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord) ([]byte, error) {
+	plaintext, err := json.Marshal(kycRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	orgKey, err := getOrgKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := getWrappedDataKey(ctx, kycRecord.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if orgKey == nil {
+		if wrapped != nil {
+			return nil, fmt.Errorf("KYC data of user %s is encrypted; %s is required in the transient map", kycRecord.UserID, orgKeyTransientField)
+		}
+		return plaintext, nil
+	}
+
+	var dataKey []byte
+	if wrapped != nil {
+		dataKey, err = unwrapDataKey(wrapped, orgKey)
+		if err == nil {
+			err = moveLegacyDataKey(ctx, kycRecord.UserID)
+		}
+	} else {
+		dataKey, err = createDataKey(ctx, kycRecord.UserID, orgKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := deriveBytes(dataKey, "record nonce", ctx.GetStub().GetTxID(), string(plaintext))[:12]
+	ciphertext, err := sealAESGCM(dataKey, nonce, plaintext, []byte(kycRecord.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt KYC data: %v", err)
+	}
+
+	return json.Marshal(&encryptedKYCRecord{
+		Encryption: kycEncryptionAlgorithm,
+		OrgKeyID:   orgKeyID(orgKey),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	})
+}
+
+// decodeKYCRecord parses a KYC record read from the private collection, decrypting it
+// with the org key from the transient map when it is encrypted
+func decodeKYCRecord(ctx contractapi.TransactionContextInterface, userId string, privateDataBytes []byte) (*KYCRecord, error) {
+	var envelope encryptedKYCRecord
+	err := json.Unmarshal(privateDataBytes, &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := privateDataBytes
+	if envelope.Encryption != "" {
+		orgKey, err := getOrgKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if orgKey == nil {
+			return nil, fmt.Errorf("KYC data of user %s is encrypted; %s is required in the transient map", userId, orgKeyTransientField)
+		}
+
+		wrapped, err := getWrappedDataKey(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		if wrapped == nil {
+			return nil, fmt.Errorf("data key of user %s has been deleted", userId)
+		}
+		dataKey, err := unwrapDataKey(wrapped, orgKey)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce, err := base64.StdEncoding.DecodeString(envelope.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KYC data nonce: %v", err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KYC data ciphertext: %v", err)
+		}
+		plaintext, err = openAESGCM(dataKey, nonce, ciphertext, []byte(userId))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt KYC data of user %s: %v", userId, err)
+		}
+	}
+
+	var kycRecord KYCRecord
+	err = json.Unmarshal(plaintext, &kycRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kycRecord, nil
+}
+
+// getPrivateKYCRecord reads and decrypts a user's KYC record, or returns nil if there is none
+func getPrivateKYCRecord(ctx contractapi.TransactionContextInterface, userId string) (*KYCRecord, error) {
+	privateDataBytes, err := ctx.GetStub().GetPrivateData("kycPrivateData", userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC data: %v", err)
+	}
+	if privateDataBytes == nil {
+		return nil, nil
+	}
+
+	return decodeKYCRecord(ctx, userId, privateDataBytes)
+}
+
+// purgeDataKey purges a user's wrapped data key, if any, and reports whether one was purged
+func purgeDataKey(ctx contractapi.TransactionContextInterface, userId string) (bool, error) {
+	wrappedBytes, collection, err := getWrappedDataKeyBytes(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if wrappedBytes == nil {
+		return false, nil
+	}
+
+	key, err := dataKeyKey(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	err = ctx.GetStub().PurgePrivateData(collection, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to purge data key: %v", err)
+	}
+
+	return true, nil
+}
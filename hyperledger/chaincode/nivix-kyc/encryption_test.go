@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// purgingMockStub adds private data purging, hashes and range queries, which shimtest
+// does not implement, and fails private data rich queries like a peer running LevelDB
+type purgingMockStub struct {
+	*shimtest.MockStub
+}
+
+// privateDataIterator iterates over a snapshot of private data entries in key order
+type privateDataIterator struct {
+	entries []*queryresult.KV
+}
+
+func (iter *privateDataIterator) HasNext() bool {
+	return len(iter.entries) > 0
+}
+
+func (iter *privateDataIterator) Next() (*queryresult.KV, error) {
+	entry := iter.entries[0]
+	iter.entries = iter.entries[1:]
+	return entry, nil
+}
+
+func (iter *privateDataIterator) Close() error {
+	return nil
+}
+
+// GetPrivateDataByRange returns the entries from startKey up to, but excluding, endKey.
+// An empty endKey leaves the range open.
+func (stub *purgingMockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	iter := &privateDataIterator{}
+	for key, value := range stub.PvtState[collection] {
+		if key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		iter.entries = append(iter.entries, &queryresult.KV{Key: key, Value: value})
+	}
+	sort.Slice(iter.entries, func(i, j int) bool { return iter.entries[i].Key < iter.entries[j].Key })
+	return iter, nil
+}
+
+func (stub *purgingMockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.GetPrivateDataByRange(collection, startKey, startKey+string(utf8.MaxRune))
+}
+
+func (stub *purgingMockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("ExecuteQuery not supported for leveldb")
+}
+
+func (stub *purgingMockStub) PurgePrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// GetPrivateDataHash returns the SHA-256 hash peers keep of every private data value
+func (stub *purgingMockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value := stub.PvtState[collection][key]
+	if value == nil {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// newEncryptionTestContext starts a transaction with the given transient map
+func newEncryptionTestContext(t *testing.T, stub *purgingMockStub, txID string, transientMap map[string][]byte) contractapi.TransactionContextInterface {
+	stub.MockTransactionStart(txID)
+	err := stub.SetTransient(transientMap)
+	if err != nil {
+		t.Fatalf("failed to set transient map: %v", err)
+	}
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	return ctx
+}
+
+func TestPurgedDataKeyMakesRecordUnreadable(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	orgKey := []byte(strings.Repeat("o", 32))
+	dataKey := []byte(strings.Repeat("d", 32))
+	kycRecord := &KYCRecord{
+		UserID:           "user123",
+		SolanaAddress:    "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		FullName:         "John Doe",
+		KYCVerified:      true,
+		VerificationDate: "2025-05-17T12:00:00Z",
+		RiskScore:        50,
+		CountryCode:      "US",
+	}
+
+	ctx := newEncryptionTestContext(t, stub, "tx1", map[string][]byte{orgKeyTransientField: orgKey})
+	_, err := encodeKYCRecord(ctx, kycRecord)
+	if err == nil {
+		t.Fatal("encodeKYCRecord created a data key without key material from the transient map")
+	}
+
+	ctx = newEncryptionTestContext(t, stub, "tx2", map[string][]byte{orgKeyTransientField: orgKey, dataKeyTransientField: dataKey})
+	encrypted, err := encodeKYCRecord(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("encodeKYCRecord failed: %v", err)
+	}
+	if bytes.Contains(encrypted, []byte(kycRecord.FullName)) {
+		t.Fatal("encrypted record contains the full name")
+	}
+
+	ctx = newEncryptionTestContext(t, stub, "tx3", map[string][]byte{orgKeyTransientField: orgKey})
+	decrypted, err := decodeKYCRecord(ctx, kycRecord.UserID, encrypted)
+	if err != nil {
+		t.Fatalf("decodeKYCRecord failed: %v", err)
+	}
+	if decrypted.FullName != kycRecord.FullName {
+		t.Fatalf("decrypted full name = %q, want %q", decrypted.FullName, kycRecord.FullName)
+	}
+
+	purged, err := purgeDataKey(ctx, kycRecord.UserID)
+	if err != nil || !purged {
+		t.Fatalf("purgeDataKey = %v, %v, want true", purged, err)
+	}
+
+	// A copy of the record kept elsewhere cannot be decrypted with the org key any more
+	ctx = newEncryptionTestContext(t, stub, "tx4", map[string][]byte{orgKeyTransientField: orgKey})
+	_, err = decodeKYCRecord(ctx, kycRecord.UserID, encrypted)
+	if err == nil {
+		t.Fatal("decodeKYCRecord succeeded after the data key was purged")
+	}
+
+	// Nor with a data key recomputed from the org key and the ledger-visible inputs
+	var envelope encryptedKYCRecord
+	err = json.Unmarshal(encrypted, &envelope)
+	if err != nil {
+		t.Fatalf("failed to unmarshal encrypted record: %v", err)
+	}
+	nonce, _ := base64.StdEncoding.DecodeString(envelope.Nonce)
+	ciphertext, _ := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+	for _, txID := range []string{"tx1", "tx2", "tx3", "tx4"} {
+		derivedKey := deriveBytes(orgKey, "data key", kycRecord.UserID, txID)
+		_, err = openAESGCM(derivedKey, nonce, ciphertext, []byte(kycRecord.UserID))
+		if err == nil {
+			t.Fatalf("record decrypted with a data key derived from transaction %s", txID)
+		}
+	}
+}
+
+func TestCopyOfRecordCollectionCannotBeDecryptedWithOrgKey(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	orgKey := []byte(strings.Repeat("o", 32))
+	kycRecord := &KYCRecord{
+		UserID:           "user123",
+		SolanaAddress:    "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		FullName:         "John Doe",
+		KYCVerified:      true,
+		VerificationDate: "2025-05-17T12:00:00Z",
+		RiskScore:        50,
+		CountryCode:      "US",
+	}
+
+	ctx := newEncryptionTestContext(t, stub, "tx1", map[string][]byte{orgKeyTransientField: orgKey, dataKeyTransientField: []byte(strings.Repeat("d", 32))})
+	encrypted, err := encodeKYCRecord(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("encodeKYCRecord failed: %v", err)
+	}
+	err = stub.PutPrivateData("kycPrivateData", kycRecord.UserID, encrypted)
+	if err != nil {
+		t.Fatalf("failed to put KYC record: %v", err)
+	}
+	key, _ := dataKeyKey(ctx, kycRecord.UserID)
+	if stub.PvtState[legacyDataKeyCollection][key] != nil {
+		t.Fatal("wrapped data key stored next to the encrypted record")
+	}
+	if stub.PvtState[dataKeyCollection][key] == nil {
+		t.Fatalf("wrapped data key not stored in %s", dataKeyCollection)
+	}
+
+	// Before any purge, a copy of kycPrivateData holding the record is still useless with the org key
+	copyStub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	copyStub.PvtState[legacyDataKeyCollection] = stub.PvtState[legacyDataKeyCollection]
+	copyCtx := newEncryptionTestContext(t, copyStub, "tx2", map[string][]byte{orgKeyTransientField: orgKey})
+	_, err = decodeKYCRecord(copyCtx, kycRecord.UserID, encrypted)
+	if err == nil {
+		t.Fatal("record decrypted from a copy of kycPrivateData and the org key")
+	}
+
+	// A data key stored next to the record by an earlier version moves on the next write
+	stub.PvtState[legacyDataKeyCollection][key] = stub.PvtState[dataKeyCollection][key]
+	delete(stub.PvtState[dataKeyCollection], key)
+	ctx = newEncryptionTestContext(t, stub, "tx3", map[string][]byte{orgKeyTransientField: orgKey})
+	_, err = encodeKYCRecord(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("encodeKYCRecord failed: %v", err)
+	}
+	if stub.PvtState[legacyDataKeyCollection][key] != nil || stub.PvtState[dataKeyCollection][key] == nil {
+		t.Fatal("wrapped data key was not moved out of kycPrivateData")
+	}
+}
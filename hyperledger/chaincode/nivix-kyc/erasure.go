@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// erasureObjectType is the composite key object type of erasure audit records
+const erasureObjectType = "erasure"
+
+// complianceRetentionYears is how long compliance records must be kept after a business
+// relationship ends, as required by AML record keeping rules
+const complianceRetentionYears = 5
+
+// ErasureRecord is the audit record of a right-to-erasure request. It holds no personal
+// data, only what is needed to show when and why the data was erased and how long the
+// retained compliance records must be kept.
+type ErasureRecord struct {
+	UserID          string   `json:"userId"`
+	LegalBasis      string   `json:"legalBasis"`
+	ErasedAt        string   `json:"erasedAt"`
+	ErasedBy        string   `json:"erasedBy"`
+	TxID            string   `json:"txId"`
+	PurgedKeys      int      `json:"purgedKeys"`
+	Addresses       []string `json:"addresses"`
+	RetainUntil     string   `json:"retainUntil"`
+	RetainedRecords string   `json:"retainedRecords"`
+}
+
+// erasureKey builds the composite key of an erasure audit record
+func erasureKey(ctx contractapi.TransactionContextInterface, userId string, txID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(erasureObjectType, []string{userId, txID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create erasure key: %v", err)
+	}
+
+	return key, nil
+}
+
+// checkNotErased refuses to store KYC data for an erased user or over an erasure tombstone.
+// The tombstone lives under the address that was the user's primary address when the data
+// was erased, and that address stays the primary one.
+func checkNotErased(ctx contractapi.TransactionContextInterface, userId string, address string) error {
+	addresses := []string{address}
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.Primary && link.Address != address {
+			addresses = append(addresses, link.Address)
+		}
+	}
+
+	for _, candidate := range addresses {
+		publicRecord, err := readPublicKYCRecord(ctx, candidate)
+		if err != nil {
+			return err
+		}
+		if publicRecord == nil || !publicRecord.Erased {
+			continue
+		}
+		if publicRecord.UserID == userId {
+			return fmt.Errorf("KYC data of user %s has been erased", userId)
+		}
+		return fmt.Errorf("KYC data of address %s has been erased", candidate)
+	}
+
+	return nil
+}
+
+// erasurePrimaryAddress returns the primary address of a user whose data is being erased.
+// It comes from the address links, which every record stored since addresses were linked
+// has, or else from the record itself when it is in plaintext.
+func erasurePrimaryAddress(ctx contractapi.TransactionContextInterface, userId string, privateDataBytes []byte) (string, error) {
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	for _, link := range links {
+		if link.Primary {
+			return link.Address, nil
+		}
+	}
+
+	var legacyRecord KYCRecord
+	err = json.Unmarshal(privateDataBytes, &legacyRecord)
+	if err != nil || legacyRecord.SolanaAddress == "" {
+		return "", fmt.Errorf("no primary address found for user %s", userId)
+	}
+
+	return legacyRecord.SolanaAddress, nil
+}
+
+// EraseUserData honours a right-to-erasure request. The user's KYC record, document
+// attestations and data key are purged from the kycPrivateData collection, including the
+// private data history kept by peers, and the public reference is replaced by a tombstone
+// that keeps only the pseudonymous user ID, the primary address and, when the user was
+// blocked by sanctions screening, the block. The other linked addresses and the shares
+// with partner organizations are revoked.
+// Compliance records are retained for the AML retention period. The tombstone is built
+// from the public reference and the address links, so the private record is purged
+// without being read and an encrypted record is erased without the org key.
+func (s *SmartContract) EraseUserData(ctx contractapi.TransactionContextInterface,
+	userId string,
+	legalBasis string) (*ErasureRecord, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(strings.TrimSpace(legalBasis)) == 0 {
+		return nil, fmt.Errorf("legalBasis must be a non-empty string")
+	}
+
+	privateDataBytes, err := ctx.GetStub().GetPrivateData("kycPrivateData", userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC data: %v", err)
+	}
+	if privateDataBytes == nil {
+		return nil, fmt.Errorf("no KYC record found for user %s", userId)
+	}
+	primaryAddress, err := erasurePrimaryAddress(ctx, userId, privateDataBytes)
+	if err != nil {
+		return nil, err
+	}
+	publicRecord, err := readPublicKYCRecord(ctx, primaryAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	// Purge the document attestations, then the KYC record itself
+	purgedKeys := 0
+	attestationIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey("kycPrivateData", attestationObjectType, []string{userId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestations: %v", err)
+	}
+	defer attestationIterator.Close()
+
+	for attestationIterator.HasNext() {
+		queryResponse, err := attestationIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().PurgePrivateData("kycPrivateData", queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge attestation: %v", err)
+		}
+		purgedKeys++
+	}
+
+	err = ctx.GetStub().PurgePrivateData("kycPrivateData", userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge KYC data: %v", err)
+	}
+	purgedKeys++
+
+	// The digest would still confirm a guessed record
+	digestKey, err := kycDigestKey(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := ctx.GetStub().GetPrivateData("kycPrivateData", digestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC digest: %v", err)
+	}
+	if digest != nil {
+		err = ctx.GetStub().PurgePrivateData("kycPrivateData", digestKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge KYC digest: %v", err)
+		}
+		purgedKeys++
+	}
+
+	// Without the data key, copies of an encrypted record kept outside the peers'
+	// private data stores can no longer be decrypted
+	purged, err := purgeDataKey(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if purged {
+		purgedKeys++
+	}
+
+	// Revoke the other linked addresses so that none of them resolves to the tombstone
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{primaryAddress}
+	for _, link := range links {
+		if link.Address == primaryAddress {
+			continue
+		}
+		addresses = append(addresses, link.Address)
+		if link.Status == AddressRevoked {
+			continue
+		}
+		link.Status = AddressRevoked
+		link.RevokedAt = now.Format(time.RFC3339)
+		link.RevocationReason = "User data erased"
+		err = putAddressLink(ctx, link)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Withdraw the data shared with partner organizations
+	shares, err := getKYCShares(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if share.Status != ShareActive {
+			continue
+		}
+		err = s.revokeKYCShare(ctx, share, "User data erased")
+		if err != nil {
+			return nil, err
+		}
+		purgedKeys++
+	}
+
+	// Replace the public reference with a tombstone
+	err = deletePublicKYCRecord(ctx, primaryAddress)
+	if err != nil {
+		return nil, err
+	}
+	tombstone := &PublicKYCRecord{
+		UserID:        userId,
+		SolanaAddress: primaryAddress,
+		KYCVerified:   false,
+		Tier:          TierNone,
+		Erased:        true,
+		ErasedAt:      now.Format(time.RFC3339),
+	}
+	if publicRecord != nil && publicRecord.ScreeningStatus == ScreeningBlocked {
+		tombstone.ScreeningStatus = ScreeningBlocked
+	}
+	err = putPublicKYCRecord(ctx, tombstone)
+	if err != nil {
+		return nil, err
+	}
+
+	erasureRecord := &ErasureRecord{
+		UserID:          userId,
+		LegalBasis:      legalBasis,
+		ErasedAt:        now.Format(time.RFC3339),
+		ErasedBy:        clientID,
+		TxID:            ctx.GetStub().GetTxID(),
+		PurgedKeys:      purgedKeys,
+		Addresses:       addresses,
+		RetainUntil:     now.AddDate(complianceRetentionYears, 0, 0).Format(dateLayout),
+		RetainedRecords: "complianceRecords",
+	}
+	auditKey, err := erasureKey(ctx, userId, erasureRecord.TxID)
+	if err != nil {
+		return nil, err
+	}
+	erasureJSON, err := json.Marshal(erasureRecord)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(auditKey, erasureJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put erasure record: %v", err)
+	}
+
+	err = emitEvent(ctx, EventKYCErased, &KYCEventPayload{
+		UserID:          userId,
+		SolanaAddress:   primaryAddress,
+		KYCVerified:     false,
+		Tier:            TierNone,
+		ScreeningStatus: tombstone.ScreeningStatus,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.RecordComplianceEvent(ctx, userId, "Data Erasure",
+		fmt.Sprintf("Personal data erased under %s, compliance records retained until %s", legalBasis, erasureRecord.RetainUntil))
+	if err != nil {
+		return nil, err
+	}
+
+	return erasureRecord, nil
+}
+
+// GetErasureRecords returns the audit records of a user's data erasures
+func (s *SmartContract) GetErasureRecords(ctx contractapi.TransactionContextInterface,
+	userId string) ([]*ErasureRecord, error) {
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(erasureObjectType, []string{userId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read erasure records: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	erasureRecords := []*ErasureRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var erasureRecord ErasureRecord
+		err = json.Unmarshal(queryResponse.Value, &erasureRecord)
+		if err != nil {
+			return nil, err
+		}
+		erasureRecords = append(erasureRecords, &erasureRecord)
+	}
+
+	return erasureRecords, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestEraseUserDataWithoutOrgKey(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	contract := new(SmartContract)
+	address := "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE"
+	linkedAddress := "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"
+
+	// An encrypted record of a user blocked by sanctions screening, with a second address
+	stub.MockTransactionStart("tx1")
+	err := stub.SetTransient(map[string][]byte{
+		orgKeyTransientField:  []byte(strings.Repeat("o", 32)),
+		dataKeyTransientField: []byte(strings.Repeat("d", 32)),
+	})
+	if err != nil {
+		t.Fatalf("failed to set transient map: %v", err)
+	}
+	kycRecord := &KYCRecord{
+		UserID:          "user123",
+		SolanaAddress:   address,
+		FullName:        "John Doe",
+		RiskScore:       90,
+		CountryCode:     "US",
+		ScreeningStatus: ScreeningBlocked,
+	}
+	encrypted, err := encodeKYCRecord(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("encodeKYCRecord failed: %v", err)
+	}
+	err = stub.PutPrivateData("kycPrivateData", kycRecord.UserID, encrypted)
+	if err != nil {
+		t.Fatalf("failed to put KYC record: %v", err)
+	}
+	err = setPrimaryAddressLink(ctx, kycRecord.UserID, address)
+	if err != nil {
+		t.Fatalf("setPrimaryAddressLink failed: %v", err)
+	}
+	err = putAddressLink(ctx, &AddressLink{Address: linkedAddress, UserID: kycRecord.UserID, Status: AddressActive})
+	if err != nil {
+		t.Fatalf("putAddressLink failed: %v", err)
+	}
+	err = putPublicKYCRecord(ctx, &PublicKYCRecord{
+		UserID:          kycRecord.UserID,
+		SolanaAddress:   address,
+		RiskScore:       90,
+		CountryCode:     "US",
+		Tier:            TierNone,
+		ScreeningStatus: ScreeningBlocked,
+	})
+	if err != nil {
+		t.Fatalf("putPublicKYCRecord failed: %v", err)
+	}
+
+	// The erasure runs without the org key in the transient map
+	stub.MockTransactionStart("tx2")
+	err = stub.SetTransient(map[string][]byte{})
+	if err != nil {
+		t.Fatalf("failed to set transient map: %v", err)
+	}
+	erasureRecord, err := contract.EraseUserData(ctx, kycRecord.UserID, "GDPR Art. 17(1)(b)")
+	if err != nil {
+		t.Fatalf("EraseUserData failed: %v", err)
+	}
+	if len(erasureRecord.Addresses) != 2 || erasureRecord.Addresses[0] != address {
+		t.Fatalf("erased addresses = %v, want the primary address first and the linked address", erasureRecord.Addresses)
+	}
+
+	if stub.PvtState["kycPrivateData"][kycRecord.UserID] != nil {
+		t.Fatal("encrypted KYC record was not purged")
+	}
+	key, _ := dataKeyKey(ctx, kycRecord.UserID)
+	if stub.PvtState[dataKeyCollection][key] != nil {
+		t.Fatal("data key was not purged")
+	}
+
+	tombstone, err := readPublicKYCRecord(ctx, address)
+	if err != nil {
+		t.Fatalf("readPublicKYCRecord failed: %v", err)
+	}
+	if tombstone == nil || !tombstone.Erased || tombstone.ScreeningStatus != ScreeningBlocked || tombstone.CountryCode != "" {
+		t.Fatalf("tombstone = %+v, want an erased record keeping only the sanctions block", tombstone)
+	}
+	link, err := readAddressLink(ctx, kycRecord.UserID, linkedAddress)
+	if err != nil {
+		t.Fatalf("readAddressLink failed: %v", err)
+	}
+	if link.Status != AddressRevoked {
+		t.Fatalf("linked address status = %s, want %s", link.Status, AddressRevoked)
+	}
+}
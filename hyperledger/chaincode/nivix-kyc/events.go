@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// kycEventName is the name of the chaincode event carrying the events of a transaction.
+// Fabric keeps a single event per transaction, so every event raised by a transaction
+// is delivered together in one EventBatch.
+const kycEventName = "NivixKYCEvents"
+
+// eventSchemaVersion is the version of the EventBatch schema. It is increased whenever
+// a field is removed or changes meaning; new fields and event types keep the version.
+const eventSchemaVersion = 1
+
+// Event types
+const (
+	EventKYCStored                = "KYCStored"
+	EventKYCStatusChanged         = "KYCStatusChanged"
+	EventKYCErased                = "KYCErased"
+	EventComplianceRecorded       = "ComplianceEventRecorded"
+	EventTransactionRecorded      = "TransactionRecorded"
+	EventTransactionStatusChanged = "TransactionStatusChanged"
+	EventTransactionRejected      = "TransactionRejected"
+	EventTravelRuleStatusChange   = "TravelRuleStatusChanged"
+)
+
+// EventBatch is the payload of the chaincode event emitted by a transaction
+type EventBatch struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	TxID          string            `json:"txId"`
+	Timestamp     string            `json:"timestamp"`
+	Events        []*ChaincodeEvent `json:"events"`
+}
+
+// ChaincodeEvent is a state change reported to event listeners. Payloads never carry
+// personal data, only identifiers and statuses already visible in the world state.
+type ChaincodeEvent struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+// KYCEventPayload describes the public state of a KYC record after a change
+type KYCEventPayload struct {
+	UserID          string `json:"userId"`
+	SolanaAddress   string `json:"solanaAddress"`
+	KYCVerified     bool   `json:"kycVerified"`
+	Tier            string `json:"tier"`
+	ScreeningStatus string `json:"screeningStatus,omitempty"`
+}
+
+// ComplianceEventPayload identifies a recorded compliance event without its description
+type ComplianceEventPayload struct {
+	UserID   string `json:"userId"`
+	Action   string `json:"action"`
+	Sequence int    `json:"sequence"`
+}
+
+// TransactionEventPayload describes a recorded, rejected or updated transaction
+type TransactionEventPayload struct {
+	TransactionID   string  `json:"transactionId"`
+	FromAddress     string  `json:"fromAddress,omitempty"`
+	ToAddress       string  `json:"toAddress,omitempty"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	Status          string  `json:"status,omitempty"`
+	PreviousStatus  string  `json:"previousStatus,omitempty"`
+	SolanaSignature string  `json:"solanaSignature,omitempty"`
+	RuleID          string  `json:"ruleId,omitempty"`
+	Reason          string  `json:"reason,omitempty"`
+}
+
+// TravelRuleEventPayload describes a change of a transfer's Travel Rule status
+type TravelRuleEventPayload struct {
+	TransactionID   string `json:"transactionId"`
+	OriginatorVASP  string `json:"originatorVasp"`
+	BeneficiaryVASP string `json:"beneficiaryVasp"`
+	Status          string `json:"status"`
+}
+
+// emitEvent adds an event to the transaction's event batch and sets the batch as the
+// transaction's chaincode event
+func emitEvent(ctx contractapi.TransactionContextInterface, eventType string, payload interface{}) error {
+	event := &ChaincodeEvent{
+		Type:    eventType,
+		Payload: payload,
+	}
+
+	events := []*ChaincodeEvent{event}
+	if kycCtx, ok := ctx.(*TransactionContext); ok {
+		kycCtx.events = append(kycCtx.events, event)
+		events = kycCtx.events
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	batchJSON, err := json.Marshal(&EventBatch{
+		SchemaVersion: eventSchemaVersion,
+		TxID:          ctx.GetStub().GetTxID(),
+		Timestamp:     now.Format(time.RFC3339Nano),
+		Events:        events,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().SetEvent(kycEventName, batchJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set chaincode event: %v", err)
+	}
+
+	return nil
+}
+
+// emitKYCEvent reports the public state of a KYC record
+func emitKYCEvent(ctx contractapi.TransactionContextInterface, eventType string, kycRecord *KYCRecord) error {
+	return emitEvent(ctx, eventType, &KYCEventPayload{
+		UserID:          kycRecord.UserID,
+		SolanaAddress:   kycRecord.SolanaAddress,
+		KYCVerified:     kycRecord.KYCVerified,
+		Tier:            effectiveTier(kycRecord),
+		ScreeningStatus: kycRecord.ScreeningStatus,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// testEventBatch is an EventBatch with undecoded payloads
+type testEventBatch struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	TxID          string `json:"txId"`
+	Timestamp     string `json:"timestamp"`
+	Events        []struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	} `json:"events"`
+}
+
+// lastTestEventBatch drains the chaincode events set so far and decodes the last one,
+// which is the event Fabric keeps for the transaction
+func lastTestEventBatch(t *testing.T, stub *shimtest.MockStub) *testEventBatch {
+	var batch *testEventBatch
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event := <-stub.ChaincodeEventsChannel
+		if event.EventName != kycEventName {
+			t.Fatalf("chaincode event name = %s, want %s", event.EventName, kycEventName)
+		}
+		batch = &testEventBatch{}
+		err := json.Unmarshal(event.Payload, batch)
+		if err != nil {
+			t.Fatalf("failed to decode event batch: %v", err)
+		}
+	}
+	if batch == nil {
+		t.Fatal("no chaincode event was set")
+	}
+
+	return batch
+}
+
+func TestEventsAreBatchedPerTransaction(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	contract := new(SmartContract)
+	address := "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE"
+
+	stub.MockTransactionStart("tx1")
+	putTestKYCRecord(t, stub.MockStub, &KYCRecord{
+		UserID:        "user123",
+		SolanaAddress: address,
+		FullName:      "John Doe",
+		DateOfBirth:   "1980-01-01",
+		CountryCode:   "US",
+	})
+	err := putPublicKYCRecord(ctx, &PublicKYCRecord{UserID: "user123", SolanaAddress: address, CountryCode: "US", Tier: TierNone})
+	if err != nil {
+		t.Fatalf("putPublicKYCRecord failed: %v", err)
+	}
+
+	err = contract.UpdateKYCStatus(ctx, "user123", address, true, "Passport checked by John Doe's bank")
+	if err != nil {
+		t.Fatalf("UpdateKYCStatus failed: %v", err)
+	}
+
+	batch := lastTestEventBatch(t, stub.MockStub)
+	if batch.SchemaVersion != eventSchemaVersion || batch.TxID != "tx1" || batch.Timestamp == "" {
+		t.Fatalf("event batch header = %d, %s, %s, want version %d of tx1 with a timestamp",
+			batch.SchemaVersion, batch.TxID, batch.Timestamp, eventSchemaVersion)
+	}
+	if len(batch.Events) != 2 || batch.Events[0].Type != EventKYCStatusChanged || batch.Events[1].Type != EventComplianceRecorded {
+		t.Fatalf("event batch = %+v, want %s and %s", batch.Events, EventKYCStatusChanged, EventComplianceRecorded)
+	}
+
+	var kycPayload KYCEventPayload
+	err = json.Unmarshal(batch.Events[0].Payload, &kycPayload)
+	if err != nil {
+		t.Fatalf("failed to decode KYC event payload: %v", err)
+	}
+	publicRecord, err := readPublicKYCRecord(ctx, address)
+	if err != nil {
+		t.Fatalf("readPublicKYCRecord failed: %v", err)
+	}
+	if kycPayload.UserID != "user123" || kycPayload.SolanaAddress != address || !kycPayload.KYCVerified || kycPayload.Tier != publicRecord.Tier {
+		t.Fatalf("KYC event payload = %+v, want the verified public state with tier %s", kycPayload, publicRecord.Tier)
+	}
+
+	// Payloads never carry personal data or compliance event descriptions
+	for _, event := range batch.Events {
+		payload := string(event.Payload)
+		for _, personal := range []string{"John Doe", "1980-01-01", "Passport"} {
+			if strings.Contains(payload, personal) {
+				t.Errorf("%s payload %s contains %q", event.Type, payload, personal)
+			}
+		}
+	}
+
+	// A new transaction starts a new batch
+	stub.MockTransactionStart("tx2")
+	ctx = new(TransactionContext)
+	ctx.SetStub(stub)
+	err = emitEvent(ctx, EventTransactionRejected, &TransactionEventPayload{TransactionID: "t1", RuleID: "default-high-risk"})
+	if err != nil {
+		t.Fatalf("emitEvent failed: %v", err)
+	}
+	batch = lastTestEventBatch(t, stub.MockStub)
+	if batch.TxID != "tx2" || len(batch.Events) != 1 || batch.Events[0].Type != EventTransactionRejected {
+		t.Fatalf("event batch of tx2 = %+v, want only %s", batch, EventTransactionRejected)
+	}
+}
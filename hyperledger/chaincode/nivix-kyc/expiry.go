@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// reviewIndexObjectType is the composite key object type of the date ordered re-verification index
+const reviewIndexObjectType = "kycReview~date~address"
+
+// expiryPoliciesConfig holds the KYC expiry policies
+const expiryPoliciesConfig = "expiryPolicies"
+
+// dateLayout is the layout of calendar dates
+const dateLayout = "2006-01-02"
+
+// KYC expiry statuses
+const (
+	ExpiryValid        = "VALID"
+	ExpiryDueForReview = "DUE_FOR_REVIEW"
+	ExpiryExpired      = "EXPIRED"
+)
+
+// ExpiryPolicy sets how long a KYC verification stays valid for records of a tier whose
+// risk score lies within [MinRiskScore, MaxRiskScore], and how many days before expiry
+// the record becomes due for review. An empty Tier applies to every tier.
+type ExpiryPolicy struct {
+	ID               string `json:"id"`
+	Tier             string `json:"tier,omitempty"`
+	MinRiskScore     int    `json:"minRiskScore"`
+	MaxRiskScore     int    `json:"maxRiskScore"`
+	ValidityDays     int    `json:"validityDays"`
+	ReviewNoticeDays int    `json:"reviewNoticeDays"`
+}
+
+// ReviewEntry is a KYC record due for re-verification
+type ReviewEntry struct {
+	SolanaAddress string `json:"solanaAddress"`
+	UserID        string `json:"userId"`
+	ReviewDate    string `json:"reviewDate"`
+	ExpiryDate    string `json:"expiryDate"`
+}
+
+// ReviewQueryResult structure used for returning paginated re-verification results
+type ReviewQueryResult struct {
+	Records             []*ReviewEntry `json:"records"`
+	FetchedRecordsCount int32          `json:"fetchedRecordsCount"`
+	Bookmark            string         `json:"bookmark"`
+}
+
+// defaultExpiryPolicies apply until an admin configures expiry policies. Higher risk
+// customers are re-verified more often.
+var defaultExpiryPolicies = []*ExpiryPolicy{
+	{ID: "default-low-risk", MinRiskScore: 0, MaxRiskScore: 40, ValidityDays: 3 * 365, ReviewNoticeDays: 60},
+	{ID: "default-medium-risk", MinRiskScore: 41, MaxRiskScore: 70, ValidityDays: 2 * 365, ReviewNoticeDays: 30},
+	{ID: "default-high-risk", MinRiskScore: 71, MaxRiskScore: 100, ValidityDays: 365, ReviewNoticeDays: 30},
+}
+
+// getExpiryPolicies reads the configured expiry policies, or a copy of the defaults when
+// none are configured
+func getExpiryPolicies(ctx contractapi.TransactionContextInterface) ([]*ExpiryPolicy, error) {
+	policies := []*ExpiryPolicy{}
+	found, err := getConfig(ctx, expiryPoliciesConfig, &policies)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		for _, policy := range defaultExpiryPolicies {
+			policyCopy := *policy
+			policies = append(policies, &policyCopy)
+		}
+	}
+
+	return policies, nil
+}
+
+// findExpiryPolicy returns the first policy matching a tier and risk score
+func findExpiryPolicy(policies []*ExpiryPolicy, tier string, riskScore int) *ExpiryPolicy {
+	for _, policy := range policies {
+		if policy.Tier != "" && policy.Tier != tier {
+			continue
+		}
+		if riskScore < policy.MinRiskScore || riskScore > policy.MaxRiskScore {
+			continue
+		}
+		return policy
+	}
+
+	return nil
+}
+
+// reviewSchedule returns the expiry and review dates of a verified KYC record.
+// ok is false when the record has no tier or no policy applies.
+func reviewSchedule(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord) (expiry time.Time, review time.Time, ok bool, err error) {
+	tier := effectiveTier(kycRecord)
+	if tier == TierNone {
+		return expiry, review, false, nil
+	}
+
+	verificationDate, err := time.Parse(time.RFC3339, kycRecord.VerificationDate)
+	if err != nil {
+		// Records stored before dates were validated have a missing or invalid date. They
+		// count as expired, and are listed first for review, until they are re-verified.
+		return expiry, review, true, nil
+	}
+
+	policies, err := getExpiryPolicies(ctx)
+	if err != nil {
+		return expiry, review, false, err
+	}
+	policy := findExpiryPolicy(policies, tier, kycRecord.RiskScore)
+	if policy == nil {
+		return expiry, review, false, nil
+	}
+
+	expiry = verificationDate.UTC().AddDate(0, 0, policy.ValidityDays)
+	review = expiry.AddDate(0, 0, -policy.ReviewNoticeDays)
+
+	return expiry, review, true, nil
+}
+
+// expiryStatus classifies a verification by its review and expiry dates at a point in time
+func expiryStatus(now time.Time, expiry time.Time, review time.Time) string {
+	if !now.Before(expiry) {
+		return ExpiryExpired
+	}
+	if !now.Before(review) {
+		return ExpiryDueForReview
+	}
+
+	return ExpiryValid
+}
+
+// applyExpiry sets the expiry date and status of a KYC record read from the private
+// collection. The dates are the ones scheduled in the record's public reference when it
+// was last written, which the re-verification index also holds. Records written before
+// expiry was scheduled are not indexed and get dates from the current policies.
+func applyExpiry(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord) error {
+	primaryAddress, err := resolveKYCAddress(ctx, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
+	publicRecord, err := readPublicKYCRecord(ctx, primaryAddress)
+	if err != nil {
+		return err
+	}
+	if publicRecord != nil && publicRecord.ExpiryDate != "" && publicRecord.ReviewDate != "" {
+		return applyPublicExpiry(ctx, kycRecord, publicRecord)
+	}
+
+	expiry, review, ok, err := reviewSchedule(ctx, kycRecord)
+	if err != nil || !ok {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	kycRecord.ExpiryDate = expiry.Format(dateLayout)
+	kycRecord.ExpiryStatus = expiryStatus(now, expiry, review)
+
+	return nil
+}
+
+// applyPublicExpiry sets the expiry date and status of a KYC record built from its public
+// reference. A verified reference stored before expiry was scheduled is due for review.
+func applyPublicExpiry(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord, publicRecord *PublicKYCRecord) error {
+	expiryDate, reviewDate := publicRecord.ExpiryDate, publicRecord.ReviewDate
+	if effectiveTier(kycRecord) == TierNone {
+		return nil
+	}
+	if expiryDate == "" || reviewDate == "" {
+		kycRecord.ExpiryStatus = ExpiryDueForReview
+		return nil
+	}
+
+	expiry, err := time.Parse(dateLayout, expiryDate)
+	if err != nil {
+		return fmt.Errorf("invalid expiryDate in public data")
+	}
+	review, err := time.Parse(dateLayout, reviewDate)
+	if err != nil {
+		return fmt.Errorf("invalid reviewDate in public data")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	kycRecord.ExpiryDate = expiryDate
+	kycRecord.ExpiryStatus = expiryStatus(now, expiry, review)
+
+	return nil
+}
+
+// setReviewSchedule records the expiry and review dates of a KYC record in its public
+// reference and moves its entry in the re-verification index
+func setReviewSchedule(ctx contractapi.TransactionContextInterface, publicRecord *PublicKYCRecord, kycRecord *KYCRecord) error {
+	if publicRecord.ReviewDate != "" {
+		oldKey, err := ctx.GetStub().CreateCompositeKey(reviewIndexObjectType, []string{publicRecord.ReviewDate, kycRecord.SolanaAddress})
+		if err != nil {
+			return fmt.Errorf("failed to create review index key: %v", err)
+		}
+		err = ctx.GetStub().DelState(oldKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete review index entry: %v", err)
+		}
+	}
+	publicRecord.ReviewDate = ""
+	publicRecord.ExpiryDate = ""
+
+	expiry, review, ok, err := reviewSchedule(ctx, kycRecord)
+	if err != nil || !ok {
+		return err
+	}
+
+	publicRecord.ExpiryDate = expiry.Format(dateLayout)
+	publicRecord.ReviewDate = review.Format(dateLayout)
+
+	reviewKey, err := ctx.GetStub().CreateCompositeKey(reviewIndexObjectType, []string{review.Format(dateLayout), kycRecord.SolanaAddress})
+	if err != nil {
+		return fmt.Errorf("failed to create review index key: %v", err)
+	}
+
+	return ctx.GetStub().PutState(reviewKey, []byte{0x00})
+}
+
+// SetExpiryPolicies replaces the KYC expiry policies. Policies are evaluated in order
+// whenever a record is stored, verified or changes tier, which schedules its expiry and
+// review dates. Records written before the change keep their scheduled dates until then.
+func (s *SmartContract) SetExpiryPolicies(ctx contractapi.TransactionContextInterface,
+	policiesJSON string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var policies []*ExpiryPolicy
+	err = json.Unmarshal([]byte(policiesJSON), &policies)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal expiry policies JSON: %v", err)
+	}
+
+	policyIDs := make(map[string]bool)
+	for _, policy := range policies {
+		if policy == nil || len(policy.ID) == 0 {
+			return fmt.Errorf("every expiry policy must have a non-empty id")
+		}
+		if policyIDs[policy.ID] {
+			return fmt.Errorf("duplicate expiry policy id %s", policy.ID)
+		}
+		policyIDs[policy.ID] = true
+
+		if _, ok := tierLevels[policy.Tier]; policy.Tier != "" && !ok {
+			return fmt.Errorf("expiry policy %s has unknown tier %s", policy.ID, policy.Tier)
+		}
+		if policy.MinRiskScore < 0 || policy.MaxRiskScore > 100 || policy.MinRiskScore > policy.MaxRiskScore {
+			return fmt.Errorf("expiry policy %s must have a risk band within 0 and 100", policy.ID)
+		}
+		if policy.ValidityDays <= 0 {
+			return fmt.Errorf("expiry policy %s validityDays must be a positive integer", policy.ID)
+		}
+		if policy.ReviewNoticeDays < 0 || policy.ReviewNoticeDays > policy.ValidityDays {
+			return fmt.Errorf("expiry policy %s reviewNoticeDays must be between 0 and validityDays", policy.ID)
+		}
+	}
+
+	return putConfig(ctx, expiryPoliciesConfig, policies)
+}
+
+// GetExpiryPolicies returns the KYC expiry policies
+func (s *SmartContract) GetExpiryPolicies(ctx contractapi.TransactionContextInterface) ([]*ExpiryPolicy, error) {
+	return getExpiryPolicies(ctx)
+}
+
+// ListKYCDueForReview returns the KYC records whose review date is before beforeDate
+// (YYYY-MM-DD), earliest first, for re-verification campaigns
+func (s *SmartContract) ListKYCDueForReview(ctx contractapi.TransactionContextInterface,
+	beforeDate string,
+	pageSize int,
+	bookmark string) (*ReviewQueryResult, error) {
+
+	if _, err := time.Parse(dateLayout, beforeDate); err != nil {
+		return nil, fmt.Errorf("beforeDate must be a YYYY-MM-DD date")
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(reviewIndexObjectType, []string{}, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &ReviewQueryResult{
+		Records:  []*ReviewEntry{},
+		Bookmark: responseMetadata.Bookmark,
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		reviewDate, solanaAddress := keyParts[0], keyParts[1]
+		if reviewDate >= beforeDate {
+			// Keys are ordered by review date, nothing later can match
+			result.Bookmark = ""
+			break
+		}
+
+		publicRecord, err := readPublicKYCRecord(ctx, solanaAddress)
+		if err != nil {
+			return nil, err
+		}
+		if publicRecord == nil {
+			continue
+		}
+
+		result.Records = append(result.Records, &ReviewEntry{
+			SolanaAddress: solanaAddress,
+			UserID:        publicRecord.UserID,
+			ReviewDate:    reviewDate,
+			ExpiryDate:    publicRecord.ExpiryDate,
+		})
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+
+	return result, nil
+}
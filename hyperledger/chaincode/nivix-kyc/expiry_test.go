@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestGetExpiryPoliciesLeavesDefaultsUnchanged(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	defaultValidityDays := defaultExpiryPolicies[0].ValidityDays
+
+	err := putConfig(ctx, expiryPoliciesConfig, []*ExpiryPolicy{
+		{ID: "short", MinRiskScore: 0, MaxRiskScore: 100, ValidityDays: 30},
+	})
+	if err != nil {
+		t.Fatalf("putConfig failed: %v", err)
+	}
+	policies, err := getExpiryPolicies(ctx)
+	if err != nil {
+		t.Fatalf("getExpiryPolicies failed: %v", err)
+	}
+	if len(policies) != 1 || policies[0].ID != "short" {
+		t.Fatalf("getExpiryPolicies returned %d policies, want the configured one", len(policies))
+	}
+	if defaultExpiryPolicies[0].ID != "default-low-risk" || defaultExpiryPolicies[0].ValidityDays != defaultValidityDays {
+		t.Fatal("reading configured policies changed the default policies")
+	}
+
+	// Without configured policies the defaults are returned as a copy
+	err = stub.DelState(configTestKey(t, stub, expiryPoliciesConfig))
+	if err != nil {
+		t.Fatalf("failed to delete config: %v", err)
+	}
+	policies, err = getExpiryPolicies(ctx)
+	if err != nil {
+		t.Fatalf("getExpiryPolicies failed: %v", err)
+	}
+	if len(policies) != len(defaultExpiryPolicies) {
+		t.Fatalf("getExpiryPolicies returned %d policies, want the %d defaults", len(policies), len(defaultExpiryPolicies))
+	}
+	policies[0].ValidityDays = 1
+	if defaultExpiryPolicies[0].ValidityDays != defaultValidityDays {
+		t.Fatal("changing a returned policy changed the default policies")
+	}
+}
+
+// configTestKey builds the world state key of a configuration entry
+func configTestKey(t *testing.T, stub *shimtest.MockStub, name string) string {
+	key, err := stub.CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		t.Fatalf("failed to create config key: %v", err)
+	}
+	return key
+}
+
+func TestGetKYCStatusUsesScheduledExpiry(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+	address := "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE"
+
+	stub.MockTransactionStart("tx1")
+	kycRecord := &KYCRecord{
+		UserID:           "user123",
+		SolanaAddress:    address,
+		FullName:         "John Doe",
+		KYCVerified:      true,
+		VerificationDate: "2025-05-17T12:00:00Z",
+		RiskScore:        30,
+		CountryCode:      "US",
+		Tier:             TierIDVerified,
+	}
+	putTestKYCRecord(t, stub, kycRecord)
+	publicRecord := &PublicKYCRecord{UserID: "user123", SolanaAddress: address, KYCVerified: true, RiskScore: 30, Tier: TierIDVerified}
+	err := setReviewSchedule(ctx, publicRecord, kycRecord)
+	if err != nil {
+		t.Fatalf("setReviewSchedule failed: %v", err)
+	}
+	err = putPublicKYCRecord(ctx, publicRecord)
+	if err != nil {
+		t.Fatalf("putPublicKYCRecord failed: %v", err)
+	}
+	if publicRecord.ExpiryDate != "2028-05-16" {
+		t.Fatalf("scheduled expiry date = %s, want 2028-05-16", publicRecord.ExpiryDate)
+	}
+
+	// Shorter policies do not move the scheduled dates of an existing record
+	err = putConfig(ctx, expiryPoliciesConfig, []*ExpiryPolicy{
+		{ID: "short", MinRiskScore: 0, MaxRiskScore: 100, ValidityDays: 30},
+	})
+	if err != nil {
+		t.Fatalf("putConfig failed: %v", err)
+	}
+	status, err := contract.GetKYCStatus(ctx, address)
+	if err != nil {
+		t.Fatalf("GetKYCStatus failed: %v", err)
+	}
+	if status.ExpiryDate != publicRecord.ExpiryDate {
+		t.Fatalf("GetKYCStatus expiry date = %s, want the scheduled %s", status.ExpiryDate, publicRecord.ExpiryDate)
+	}
+	reviewKey, err := stub.CreateCompositeKey(reviewIndexObjectType, []string{publicRecord.ReviewDate, address})
+	if err != nil {
+		t.Fatalf("failed to create review index key: %v", err)
+	}
+	if entry, _ := stub.GetState(reviewKey); entry == nil {
+		t.Fatalf("no re-verification index entry for the scheduled review date %s", publicRecord.ReviewDate)
+	}
+}
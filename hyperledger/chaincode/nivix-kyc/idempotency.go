@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// TransactionConflictCode prefixes the message of a TransactionConflictError, so that
+// clients can recognize the error in the chaincode response
+const TransactionConflictCode = "TRANSACTION_CONFLICT"
+
+// transactionContent is the content of a transaction as submitted to RecordTransaction.
+// Its JSON encoding, with the fields in this order, is hashed into the content hash.
+type transactionContent struct {
+	TransactionID       string `json:"transactionId"`
+	FromAddress         string `json:"fromAddress"`
+	ToAddress           string `json:"toAddress"`
+	Amount              string `json:"amount"`
+	SourceCurrency      string `json:"sourceCurrency"`
+	DestinationCurrency string `json:"destinationCurrency"`
+	Memo                string `json:"memo"`
+	Timestamp           string `json:"timestamp"`
+}
+
+// TransactionConflictError is returned when a transaction ID is recorded again with
+// different content
+type TransactionConflictError struct {
+	TransactionID string
+	RecordedHash  string
+	SubmittedHash string
+}
+
+// Error describes the conflict with both content hashes
+func (e *TransactionConflictError) Error() string {
+	return fmt.Sprintf("%s: transaction %s is already recorded with content hash %s, the submitted content hashes to %s",
+		TransactionConflictCode, e.TransactionID, e.RecordedHash, e.SubmittedHash)
+}
+
+// transactionContentHash returns the hex SHA-256 hash of a transaction's submitted content
+func transactionContentHash(transaction *TransactionRecord) (string, error) {
+	contentJSON, err := json.Marshal(&transactionContent{
+		TransactionID:       transaction.TransactionID,
+		FromAddress:         transaction.FromAddress,
+		ToAddress:           transaction.ToAddress,
+		Amount:              transaction.Amount,
+		SourceCurrency:      transaction.SourceCurrency,
+		DestinationCurrency: transaction.DestinationCurrency,
+		Memo:                transaction.Memo,
+		Timestamp:           transaction.Timestamp,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(contentJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// checkDuplicateTransaction compares a transaction with the record already stored under
+// its ID. It reports whether the same content is already recorded, and returns a
+// TransactionConflictError if different content is.
+func checkDuplicateTransaction(existing *TransactionRecord, transaction *TransactionRecord) (bool, error) {
+	if existing == nil {
+		return false, nil
+	}
+
+	// Records written before content hashes existed are hashed from their fields
+	recordedHash := existing.ContentHash
+	if recordedHash == "" {
+		var err error
+		recordedHash, err = transactionContentHash(existing)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if recordedHash != transaction.ContentHash {
+		return false, &TransactionConflictError{
+			TransactionID: transaction.TransactionID,
+			RecordedHash:  recordedHash,
+			SubmittedHash: transaction.ContentHash,
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransactionContentHash(t *testing.T) {
+	transaction := &TransactionRecord{
+		TransactionID:       "t1",
+		FromAddress:         "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		ToAddress:           "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM",
+		Amount:              "100",
+		SourceCurrency:      "USD",
+		DestinationCurrency: "EUR",
+		Memo:                "rent",
+		Timestamp:           "2025-06-01T12:00:00Z",
+	}
+	hash, err := transactionContentHash(transaction)
+	if err != nil {
+		t.Fatalf("transactionContentHash failed: %v", err)
+	}
+	if len(hash) != 64 {
+		t.Fatalf("transactionContentHash = %s, want a hex SHA-256 hash", hash)
+	}
+
+	// State the ledger adds to a recorded transaction is not content
+	recorded := *transaction
+	recorded.Status = TransactionConfirmed
+	recorded.SolanaSignature = testSolanaSignature(1)
+	recorded.SpentAt = "2025-06-01T12:00:05.000000000Z"
+	recorded.Transitions = []*TransactionTransition{{FromStatus: TransactionInitiated, ToStatus: TransactionValidated}}
+	if recordedHash, _ := transactionContentHash(&recorded); recordedHash != hash {
+		t.Errorf("content hash changed with the lifecycle state: %s, want %s", recordedHash, hash)
+	}
+
+	for _, change := range []func(*TransactionRecord){
+		func(r *TransactionRecord) { r.TransactionID = "t2" },
+		func(r *TransactionRecord) { r.FromAddress = r.ToAddress },
+		func(r *TransactionRecord) { r.ToAddress = r.FromAddress },
+		func(r *TransactionRecord) { r.Amount = "100.0" },
+		func(r *TransactionRecord) { r.SourceCurrency = "EUR" },
+		func(r *TransactionRecord) { r.DestinationCurrency = "USD" },
+		func(r *TransactionRecord) { r.Memo = "" },
+		func(r *TransactionRecord) { r.Timestamp = "2025-06-01T12:00:01Z" },
+	} {
+		changed := *transaction
+		change(&changed)
+		if changedHash, _ := transactionContentHash(&changed); changedHash == hash {
+			t.Errorf("content hash of %+v did not change", changed)
+		}
+	}
+}
+
+func TestCheckDuplicateTransaction(t *testing.T) {
+	transaction := &TransactionRecord{TransactionID: "t1", FromAddress: "A", ToAddress: "B", Amount: "100", SourceCurrency: "USD"}
+	var err error
+	transaction.ContentHash, err = transactionContentHash(transaction)
+	if err != nil {
+		t.Fatalf("transactionContentHash failed: %v", err)
+	}
+
+	duplicate, err := checkDuplicateTransaction(nil, transaction)
+	if duplicate || err != nil {
+		t.Errorf("checkDuplicateTransaction without a record = %v, %v, want a new transaction", duplicate, err)
+	}
+
+	stored := *transaction
+	stored.Status = TransactionConfirmed
+	duplicate, err = checkDuplicateTransaction(&stored, transaction)
+	if !duplicate || err != nil {
+		t.Errorf("checkDuplicateTransaction of the same content = %v, %v, want a duplicate", duplicate, err)
+	}
+
+	// Records written before content hashes existed are hashed from their fields
+	legacy := &TransactionRecord{TransactionID: "t1", FromAddress: "A", ToAddress: "B", Amount: "100", SourceCurrency: "USD", Status: TransactionCompleted}
+	duplicate, err = checkDuplicateTransaction(legacy, transaction)
+	if !duplicate || err != nil {
+		t.Errorf("checkDuplicateTransaction of a legacy record = %v, %v, want a duplicate", duplicate, err)
+	}
+
+	legacy.Amount = "150"
+	duplicate, err = checkDuplicateTransaction(legacy, transaction)
+	var conflict *TransactionConflictError
+	if duplicate || !errors.As(err, &conflict) {
+		t.Fatalf("checkDuplicateTransaction of different content = %v, %v, want a TransactionConflictError", duplicate, err)
+	}
+	if conflict.TransactionID != "t1" || conflict.SubmittedHash != transaction.ContentHash || conflict.RecordedHash == transaction.ContentHash {
+		t.Errorf("conflict = %+v, want the recorded and submitted hashes of t1", conflict)
+	}
+	if !strings.HasPrefix(err.Error(), TransactionConflictCode+": ") ||
+		!strings.Contains(err.Error(), conflict.RecordedHash) || !strings.Contains(err.Error(), conflict.SubmittedHash) {
+		t.Errorf("conflict message = %s, want the %s code and both hashes", err, TransactionConflictCode)
+	}
+}
+
+func TestRecordTransactionRetries(t *testing.T) {
+	ctx, stub := newLifecycleTestContext(t, "t1")
+	contract := new(SmartContract)
+
+	_, err := contract.TransitionTransaction(ctx, "t1", TransactionValidated, "")
+	if err != nil {
+		t.Fatalf("TransitionTransaction failed: %v", err)
+	}
+
+	// A retry in a later transaction succeeds and leaves the record and spend unchanged
+	stub.MockTransactionStart("tx2")
+	err = contract.RecordTransaction(ctx, "t1", "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		"9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "100", "USD", "EUR", "", "2025-06-01T12:00:00Z")
+	if err != nil {
+		t.Fatalf("RecordTransaction retry failed: %v", err)
+	}
+	transaction, err := contract.GetTransaction(ctx, "t1")
+	if err != nil {
+		t.Fatalf("GetTransaction failed: %v", err)
+	}
+	if transaction.Status != TransactionValidated || len(transaction.Transitions) != 1 {
+		t.Errorf("transaction after retry = %+v, want it still VALIDATED", transaction)
+	}
+	checkTestDailySpend(t, ctx, 100)
+
+	// A different transfer under the same ID is a conflict
+	err = contract.RecordTransaction(ctx, "t1", "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		"9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "250", "USD", "EUR", "", "2025-06-01T12:00:00Z")
+	var conflict *TransactionConflictError
+	if !errors.As(err, &conflict) || conflict.TransactionID != "t1" {
+		t.Fatalf("RecordTransaction with different content = %v, want a TransactionConflictError", err)
+	}
+	if conflict.RecordedHash != transaction.ContentHash {
+		t.Errorf("conflict recorded hash = %s, want %s", conflict.RecordedHash, transaction.ContentHash)
+	}
+	checkTestDailySpend(t, ctx, 100)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite key object types of public KYC records, transactions and transaction indexes.
+// Configuration entries live under configObjectType. Keeping every kind of value under its
+// own object type stops user-supplied IDs and addresses from colliding with each other.
+const (
+	kycObjectType   = "kyc"
+	txObjectType    = "tx"
+	indexObjectType = "idx"
+)
+
+// Prefixes of the simple keys used before the keyspace was namespaced. Public KYC records
+// were stored under their bare address.
+const (
+	legacyTxKeyPrefix        = "tx_"
+	legacyFromIndexKeyPrefix = "from~tx~"
+	legacyToIndexKeyPrefix   = "to~tx~"
+)
+
+// keyspaceVersionConfig records the keyspace layout the world state has been migrated to
+const keyspaceVersionConfig = "keyspaceVersion"
+
+// currentKeyspaceVersion is the namespaced layout; version 1 is the layout of simple keys
+const currentKeyspaceVersion = 2
+
+// stateEntry is a world state key and its value
+type stateEntry struct {
+	Key   string
+	Value []byte
+}
+
+// scanObjectType returns up to pageSize world state entries of an object type in key order,
+// starting at startKey, and the key the next page starts at, which is empty after the last
+// page. GetStateByRange does not accept composite keys and Fabric refuses writes after a
+// paginated query, so batch functions that write cannot use either to resume at startKey.
+// On CouchDB the page is read with a rich query on the _id range beginning at startKey;
+// LevelDB has no such query, so there the keys before startKey are skipped.
+func scanObjectType(ctx contractapi.TransactionContextInterface,
+	objectType string,
+	startKey string,
+	pageSize int) ([]*stateEntry, string, error) {
+
+	return scanPartialCompositeKey(ctx, objectType, []string{}, startKey, pageSize)
+}
+
+// scanPartialCompositeKey pages through the world state entries of an object type whose
+// keys begin with the given attributes, like scanObjectType
+func scanPartialCompositeKey(ctx contractapi.TransactionContextInterface,
+	objectType string,
+	attributes []string,
+	startKey string,
+	pageSize int) ([]*stateEntry, string, error) {
+
+	firstKey, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create %s key: %v", objectType, err)
+	}
+	if startKey < firstKey {
+		startKey = firstKey
+	}
+
+	queryString, err := json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{
+			"_id": map[string]interface{}{
+				"$gte": startKey,
+				"$lt":  firstKey + string(utf8.MaxRune),
+			},
+		},
+		"sort": []map[string]string{{"_id": "asc"}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	richQuery := true
+	var resultsIterator shim.StateQueryIteratorInterface
+	resultsIterator, err = ctx.GetStub().GetQueryResult(string(queryString))
+	if err != nil {
+		if !strings.Contains(strings.ToLower(err.Error()), "leveldb") {
+			return nil, "", fmt.Errorf("failed to run rich query: %v", err)
+		}
+		richQuery = false
+		resultsIterator, err = ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	defer resultsIterator.Close()
+
+	entries := []*stateEntry{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		if queryResult.Key < startKey {
+			continue
+		}
+		if len(entries) == pageSize {
+			return entries, queryResult.Key, nil
+		}
+
+		// Rich query results are not checked for conflicts at commit, so read each
+		// value again into the read set before the caller rewrites it
+		value := queryResult.Value
+		if richQuery {
+			value, err = ctx.GetStub().GetState(queryResult.Key)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to read %s: %v", queryResult.Key, err)
+			}
+			if value == nil {
+				continue
+			}
+		}
+		entries = append(entries, &stateEntry{Key: queryResult.Key, Value: value})
+	}
+
+	return entries, "", nil
+}
+
+// KeyspaceMigrationResult reports a batch of the keyspace migration
+type KeyspaceMigrationResult struct {
+	Scanned  int    `json:"scanned"`
+	Moved    int    `json:"moved"`
+	Skipped  int    `json:"skipped"`
+	Bookmark string `json:"bookmark"`
+}
+
+// kycKey builds the key of the public KYC record stored under an address
+func kycKey(ctx contractapi.TransactionContextInterface, address string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(kycObjectType, []string{address})
+	if err != nil {
+		return "", fmt.Errorf("failed to create KYC key: %v", err)
+	}
+
+	return key, nil
+}
+
+// txKey builds the key of a transaction record
+func txKey(ctx contractapi.TransactionContextInterface, transactionID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(txObjectType, []string{transactionID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create transaction key: %v", err)
+	}
+
+	return key, nil
+}
+
+// indexKey builds the key of an index entry
+func indexKey(ctx contractapi.TransactionContextInterface, index string, attributes ...string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(indexObjectType, append([]string{index}, attributes...))
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s index key: %v", index, err)
+	}
+
+	return key, nil
+}
+
+// getStateWithLegacyKey reads a value from its namespaced key, falling back to the simple
+// key it was stored under before the keyspace migration
+func getStateWithLegacyKey(ctx contractapi.TransactionContextInterface, key string, legacyKey string) ([]byte, error) {
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil || value != nil {
+		return value, err
+	}
+
+	return ctx.GetStub().GetState(legacyKey)
+}
+
+// deleteLegacyKey removes the simple key a value was stored under before the keyspace
+// migration, if it still exists
+func deleteLegacyKey(ctx contractapi.TransactionContextInterface, legacyKey string) error {
+	value, err := ctx.GetStub().GetState(legacyKey)
+	if err != nil {
+		return fmt.Errorf("failed to read legacy key: %v", err)
+	}
+	if value == nil {
+		return nil
+	}
+
+	return ctx.GetStub().DelState(legacyKey)
+}
+
+// migrateLegacyKey moves the value of a simple key to its namespaced key and reports
+// whether the key belonged to the legacy layout
+func migrateLegacyKey(ctx contractapi.TransactionContextInterface, legacyKey string, value []byte) (bool, error) {
+	var key string
+	var err error
+	switch {
+	case strings.HasPrefix(legacyKey, legacyTxKeyPrefix):
+		key, err = txKey(ctx, strings.TrimPrefix(legacyKey, legacyTxKeyPrefix))
+	case strings.HasPrefix(legacyKey, legacyFromIndexKeyPrefix), strings.HasPrefix(legacyKey, legacyToIndexKeyPrefix):
+		// Index keys are <prefix><address>~<transaction ID> and hold the transaction ID
+		index, prefix := txFromIndex, legacyFromIndexKeyPrefix
+		if strings.HasPrefix(legacyKey, legacyToIndexKeyPrefix) {
+			index, prefix = txToIndex, legacyToIndexKeyPrefix
+		}
+		transactionID := string(value)
+		address := strings.TrimPrefix(legacyKey, prefix)
+		if !strings.HasSuffix(address, "~"+transactionID) {
+			return false, nil
+		}
+		key, err = indexKey(ctx, index, strings.TrimSuffix(address, "~"+transactionID), transactionID)
+	default:
+		publicRecord, decodeErr := decodePublicKYCRecord(value)
+		if decodeErr != nil || publicRecord.SolanaAddress != legacyKey {
+			return false, nil
+		}
+		key, err = kycKey(ctx, legacyKey)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = ctx.GetStub().PutState(key, value)
+	if err != nil {
+		return false, fmt.Errorf("failed to move %s: %v", legacyKey, err)
+	}
+	err = ctx.GetStub().DelState(legacyKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete %s: %v", legacyKey, err)
+	}
+
+	return true, nil
+}
+
+// MigrateKeyspace moves public KYC records, transactions and transaction indexes stored
+// under simple keys to their namespaced composite keys. Values are moved unchanged. Each
+// call scans up to pageSize simple keys and returns a bookmark to pass to the next call;
+// the call that returns an empty bookmark marks the migration complete, after which the
+// function refuses to run again. Simple keys that belong to no known layout are skipped
+// and left in place.
+func (s *SmartContract) MigrateKeyspace(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	bookmark string) (*KeyspaceMigrationResult, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	version := 1
+	_, err = getConfig(ctx, keyspaceVersionConfig, &version)
+	if err != nil {
+		return nil, err
+	}
+	if version >= currentKeyspaceVersion {
+		return nil, fmt.Errorf("keyspace has already been migrated to version %d", version)
+	}
+
+	startKey := ""
+	if bookmark != "" {
+		startKeyBytes, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark: %v", err)
+		}
+		startKey = string(startKeyBytes)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &KeyspaceMigrationResult{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if result.Scanned == pageSize {
+			result.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(queryResult.Key))
+			break
+		}
+		result.Scanned++
+
+		moved, err := migrateLegacyKey(ctx, queryResult.Key, queryResult.Value)
+		if err != nil {
+			return nil, err
+		}
+		if moved {
+			result.Moved++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	if result.Bookmark == "" {
+		err = putConfig(ctx, keyspaceVersionConfig, currentKeyspaceVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetKeyspaceVersion returns the keyspace layout the world state has been migrated to
+func (s *SmartContract) GetKeyspaceVersion(ctx contractapi.TransactionContextInterface) (int, error) {
+	version := 1
+	_, err := getConfig(ctx, keyspaceVersionConfig, &version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// levelDBMockStub fails rich queries like a peer running LevelDB, and like a peer leaves
+// composite keys out of range queries, neither of which shimtest does
+type levelDBMockStub struct {
+	*shimtest.MockStub
+}
+
+func (stub *levelDBMockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("ExecuteQuery not supported for leveldb")
+}
+
+func (stub *levelDBMockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = "\x01"
+	}
+	if endKey == "" {
+		endKey = string(utf8.MaxRune)
+	}
+	return stub.MockStub.GetStateByRange(startKey, endKey)
+}
+
+func TestScanObjectTypePages(t *testing.T) {
+	stub := &levelDBMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	for _, address := range []string{"A3", "A1", "A5", "A2", "A4"} {
+		err := putPublicKYCRecord(ctx, &PublicKYCRecord{UserID: "user-" + address, SolanaAddress: address})
+		if err != nil {
+			t.Fatalf("putPublicKYCRecord failed: %v", err)
+		}
+	}
+	err := putTransaction(ctx, &TransactionRecord{TransactionID: "t1", Amount: "1"})
+	if err != nil {
+		t.Fatalf("putTransaction failed: %v", err)
+	}
+
+	addresses := ""
+	startKey := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("scanObjectType did not finish")
+		}
+		entries, nextKey, err := scanObjectType(ctx, kycObjectType, startKey, 2)
+		if err != nil {
+			t.Fatalf("scanObjectType failed: %v", err)
+		}
+		if len(entries) > 2 {
+			t.Fatalf("scanObjectType returned %d entries, want at most 2", len(entries))
+		}
+		for _, entry := range entries {
+			_, attributes, err := stub.SplitCompositeKey(entry.Key)
+			if err != nil {
+				t.Fatalf("SplitCompositeKey failed: %v", err)
+			}
+			addresses += attributes[0] + " "
+		}
+		if nextKey == "" {
+			break
+		}
+		startKey = nextKey
+	}
+	if addresses != "A1 A2 A3 A4 A5 " {
+		t.Fatalf("scanned %s, want the KYC records A1 to A5 in key order", addresses)
+	}
+
+	entries, nextKey, err := scanPartialCompositeKey(ctx, kycObjectType, []string{"A4"}, "", 10)
+	if err != nil {
+		t.Fatalf("scanPartialCompositeKey failed: %v", err)
+	}
+	if len(entries) != 1 || nextKey != "" {
+		t.Fatalf("scanPartialCompositeKey returned %d entries and next key %q, want only A4", len(entries), nextKey)
+	}
+}
+
+func TestMigrateKeyspace(t *testing.T) {
+	stub := &levelDBMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	contract := new(SmartContract)
+	stub.MockTransactionStart("tx1")
+
+	// The simple keys of the first layout, and a key of no known layout
+	legacy := map[string]string{
+		"A1":             `{"userId":"user1","solanaAddress":"A1","kycVerified":"true","riskScore":"30"}`,
+		"tx_t1":          `{"transactionId":"t1","fromAddress":"A1","toAddress":"B1","amount":"5"}`,
+		"from~tx~A1~t1":  "t1",
+		"to~tx~B1~t1":    "t1",
+		"from~tx~A1~bad": "t2",
+		"notes":          "kept",
+	}
+	for key, value := range legacy {
+		err := stub.PutState(key, []byte(value))
+		if err != nil {
+			t.Fatalf("failed to put %s: %v", key, err)
+		}
+	}
+
+	moved, skipped := 0, 0
+	bookmark := ""
+	for calls := 0; ; calls++ {
+		if calls > 10 {
+			t.Fatal("MigrateKeyspace did not finish")
+		}
+		result, err := contract.MigrateKeyspace(ctx, 2, bookmark)
+		if err != nil {
+			t.Fatalf("MigrateKeyspace failed: %v", err)
+		}
+		moved += result.Moved
+		skipped += result.Skipped
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if moved != 4 || skipped != 2 {
+		t.Fatalf("moved %d keys and skipped %d, want 4 and 2", moved, skipped)
+	}
+
+	for key := range legacy {
+		value, err := stub.GetState(key)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+		kept := key == "notes" || key == "from~tx~A1~bad"
+		if (value != nil) != kept {
+			t.Errorf("simple key %s present %v, want %v", key, value != nil, kept)
+		}
+	}
+
+	record, err := readPublicKYCRecord(ctx, "A1")
+	if err != nil || record == nil || record.UserID != "user1" {
+		t.Fatalf("readPublicKYCRecord = %+v, %v, want the moved record", record, err)
+	}
+	transaction, err := readTransaction(ctx, "t1")
+	if err != nil || transaction == nil || transaction.Amount != "5" {
+		t.Fatalf("readTransaction = %+v, %v, want the moved transaction", transaction, err)
+	}
+	for _, index := range []struct {
+		name    string
+		address string
+	}{{txFromIndex, "A1"}, {txToIndex, "B1"}} {
+		key, err := indexKey(ctx, index.name, index.address, "t1")
+		if err != nil {
+			t.Fatalf("indexKey failed: %v", err)
+		}
+		value, err := stub.GetState(key)
+		if err != nil || string(value) != "t1" {
+			t.Errorf("%s index entry of %s = %q, %v, want t1", index.name, index.address, value, err)
+		}
+	}
+
+	version, err := contract.GetKeyspaceVersion(ctx)
+	if err != nil || version != currentKeyspaceVersion {
+		t.Fatalf("GetKeyspaceVersion = %d, %v, want %d", version, err, currentKeyspaceVersion)
+	}
+	if _, err = contract.MigrateKeyspace(ctx, 2, ""); err == nil {
+		t.Fatal("MigrateKeyspace ran again after the migration completed")
+	}
+}
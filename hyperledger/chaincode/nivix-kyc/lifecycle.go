@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transaction lifecycle statuses. RecordTransaction records a transaction as INITIATED and
+// the bridge moves it through the later statuses with TransitionTransaction. COMPLETED is
+// the status of transactions recorded before the lifecycle existed.
+const (
+	TransactionInitiated = "INITIATED"
+	TransactionValidated = "VALIDATED"
+	TransactionSubmitted = "SUBMITTED_TO_SOLANA"
+	TransactionConfirmed = "CONFIRMED"
+	TransactionFailed    = "FAILED"
+	TransactionReversed  = "REVERSED"
+	TransactionCompleted = "COMPLETED"
+)
+
+// transactionTransitions lists the statuses a transaction may move to from each status.
+// REVERSED is final.
+var transactionTransitions = map[string][]string{
+	TransactionInitiated: {TransactionValidated, TransactionFailed},
+	TransactionValidated: {TransactionSubmitted, TransactionFailed},
+	TransactionSubmitted: {TransactionConfirmed, TransactionFailed},
+	TransactionConfirmed: {TransactionReversed},
+	TransactionFailed:    {TransactionReversed},
+	TransactionCompleted: {TransactionReversed},
+}
+
+// TransitionEvidence is the evidence supplied with a transaction status change
+type TransitionEvidence struct {
+	SolanaSignature string `json:"solanaSignature,omitempty"`
+	Slot            uint64 `json:"slot,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+// TransactionTransition records a status change of a transaction and who made it
+type TransactionTransition struct {
+	FromStatus      string `json:"fromStatus"`
+	ToStatus        string `json:"toStatus"`
+	ChangedBy       string `json:"changedBy"`
+	ChangedByMSPID  string `json:"changedByMspId"`
+	SolanaSignature string `json:"solanaSignature,omitempty"`
+	Slot            uint64 `json:"slot,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	ChangedAt       string `json:"changedAt"`
+	TxID            string `json:"txId"`
+}
+
+// releasesSpend reports whether a status change ends a transfer without moving funds, so
+// that its amount no longer counts towards the sender's velocity limits. A failed transfer
+// has already released its spend when it is reversed.
+func releasesSpend(fromStatus string, toStatus string) bool {
+	switch toStatus {
+	case TransactionFailed:
+		return true
+	case TransactionReversed:
+		return fromStatus != TransactionFailed
+	}
+
+	return false
+}
+
+// releaseSpend writes a negative spend delta for a transaction, dated at its spend so that
+// both rows leave the rolling windows together. Transactions recorded before spentAt
+// existed have no known spend time and release nothing.
+func releaseSpend(ctx contractapi.TransactionContextInterface, transaction *TransactionRecord, amount float64) error {
+	if transaction.SpentAt == "" {
+		return nil
+	}
+	spentAt, err := time.Parse(keyTimeLayout, transaction.SpentAt)
+	if err != nil {
+		return fmt.Errorf("invalid spentAt on transaction %s: %v", transaction.TransactionID, err)
+	}
+
+	return addSpendDelta(ctx, transaction.FromAddress, transaction.SourceCurrency, -amount, spentAt)
+}
+
+// isLegalTransition reports whether a transaction may move from one status to another
+func isLegalTransition(fromStatus string, toStatus string) bool {
+	for _, status := range transactionTransitions[fromStatus] {
+		if status == toStatus {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTransitionEvidence decodes the evidence of a status change and checks that it
+// carries what the new status requires
+func parseTransitionEvidence(evidenceJSON string, toStatus string) (*TransitionEvidence, error) {
+	evidence := &TransitionEvidence{}
+	if evidenceJSON != "" {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(evidenceJSON)))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(evidence)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal evidence JSON: %v", err)
+		}
+	}
+
+	if evidence.SolanaSignature != "" {
+		signature, err := decodeBase58(evidence.SolanaSignature)
+		if err != nil || len(signature) != 64 {
+			return nil, fmt.Errorf("%s is not a valid Solana transaction signature", evidence.SolanaSignature)
+		}
+	}
+
+	switch toStatus {
+	case TransactionSubmitted:
+		if evidence.SolanaSignature == "" {
+			return nil, fmt.Errorf("a solanaSignature is required to move a transaction to %s", toStatus)
+		}
+	case TransactionConfirmed:
+		if evidence.SolanaSignature == "" && evidence.Slot == 0 {
+			return nil, fmt.Errorf("a solanaSignature or slot is required to move a transaction to %s", toStatus)
+		}
+	case TransactionFailed, TransactionReversed:
+		if evidence.Reason == "" {
+			return nil, fmt.Errorf("a reason is required to move a transaction to %s", toStatus)
+		}
+	}
+
+	return evidence, nil
+}
+
+// readTransaction reads a transaction record, or returns nil if there is none
+func readTransaction(ctx contractapi.TransactionContextInterface, transactionID string) (*TransactionRecord, error) {
+	key, err := txKey(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	transactionJSON, err := getStateWithLegacyKey(ctx, key, legacyTxKeyPrefix+transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transaction: %v", err)
+	}
+	if transactionJSON == nil {
+		return nil, nil
+	}
+
+	var transaction TransactionRecord
+	err = json.Unmarshal(transactionJSON, &transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// putTransaction writes a transaction record under its transaction key. Records written
+// before content hashes existed get theirs on their first update.
+func putTransaction(ctx contractapi.TransactionContextInterface, transaction *TransactionRecord) error {
+	if transaction.ContentHash == "" {
+		contentHash, err := transactionContentHash(transaction)
+		if err != nil {
+			return err
+		}
+		transaction.ContentHash = contentHash
+	}
+
+	transactionJSON, err := json.Marshal(transaction)
+	if err != nil {
+		return err
+	}
+
+	key, err := txKey(ctx, transaction.TransactionID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, transactionJSON)
+	if err != nil {
+		return fmt.Errorf("failed to record transaction: %v", err)
+	}
+
+	// A transaction still stored under its tx_ key moves to the namespaced key
+	return deleteLegacyKey(ctx, legacyTxKeyPrefix+transaction.TransactionID)
+}
+
+// TransitionTransaction moves a transaction to the next status of its lifecycle:
+// INITIATED → VALIDATED → SUBMITTED_TO_SOLANA → CONFIRMED or FAILED → REVERSED. A
+// transaction may also fail before it is submitted. evidenceJSON is a TransitionEvidence;
+// submission needs the Solana transaction signature, confirmation the signature or slot,
+// and failure and reversal a reason. Every transition is recorded on the transaction with
+// the submitting client. Only the bridge and administrators may move transactions. A
+// transaction that fails or is reversed no longer counts towards the sender's velocity
+// limits.
+func (s *SmartContract) TransitionTransaction(ctx contractapi.TransactionContextInterface,
+	transactionId string,
+	newStatus string,
+	evidenceJSON string) (*TransactionRecord, error) {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := s.GetTransaction(ctx, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if !isLegalTransition(transaction.Status, newStatus) {
+		return nil, fmt.Errorf("transaction %s cannot move from %s to %s", transactionId, transaction.Status, newStatus)
+	}
+
+	evidence, err := parseTransitionEvidence(evidenceJSON, newStatus)
+	if err != nil {
+		return nil, err
+	}
+	if evidence.SolanaSignature != "" && transaction.SolanaSignature != "" && evidence.SolanaSignature != transaction.SolanaSignature {
+		return nil, fmt.Errorf("solanaSignature does not match the signature %s submitted for transaction %s", transaction.SolanaSignature, transactionId)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identity: %v", err)
+	}
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction.Transitions = append(transaction.Transitions, &TransactionTransition{
+		FromStatus:      transaction.Status,
+		ToStatus:        newStatus,
+		ChangedBy:       clientID,
+		ChangedByMSPID:  clientMSPID,
+		SolanaSignature: evidence.SolanaSignature,
+		Slot:            evidence.Slot,
+		Reason:          evidence.Reason,
+		ChangedAt:       now.Format(time.RFC3339),
+		TxID:            ctx.GetStub().GetTxID(),
+	})
+	previousStatus := transaction.Status
+	transaction.Status = newStatus
+	if evidence.SolanaSignature != "" {
+		transaction.SolanaSignature = evidence.SolanaSignature
+	}
+	if evidence.Slot != 0 {
+		transaction.SolanaSlot = evidence.Slot
+	}
+
+	err = putTransaction(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transactions recorded before amountValue existed only hold the amount string
+	amount, _ := strconv.ParseFloat(transaction.Amount, 64)
+	if releasesSpend(previousStatus, newStatus) {
+		err = releaseSpend(ctx, transaction, amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = emitEvent(ctx, EventTransactionStatusChanged, &TransactionEventPayload{
+		TransactionID:   transaction.TransactionID,
+		FromAddress:     transaction.FromAddress,
+		ToAddress:       transaction.ToAddress,
+		Amount:          amount,
+		Currency:        transaction.SourceCurrency,
+		Status:          transaction.Status,
+		PreviousStatus:  previousStatus,
+		SolanaSignature: transaction.SolanaSignature,
+		Reason:          evidence.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// testSolanaSignature encodes a 64-byte signature derived from seed as a Solana
+// transaction signature
+func testSolanaSignature(seed int) string {
+	signature := sha512.Sum512([]byte(fmt.Sprint(seed)))
+	return testBase58(signature[:])
+}
+
+// newLifecycleTestContext starts a bridge transaction with a recorded USD transfer of 100
+func newLifecycleTestContext(t *testing.T, transactionID string) (*TransactionContext, *shimtest.MockStub) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", attrs: map[string]string{"nivix.bridge": "true"}})
+	stub.MockTransactionStart("tx1")
+
+	err := new(SmartContract).RecordTransaction(ctx, transactionID, "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		"9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "100", "USD", "EUR", "", "2025-06-01T12:00:00Z")
+	if err != nil {
+		t.Fatalf("RecordTransaction failed: %v", err)
+	}
+
+	return ctx, stub
+}
+
+// checkTestDailySpend checks the sender's daily USD spend
+func checkTestDailySpend(t *testing.T, ctx *TransactionContext, want float64) {
+	totals, err := getSpendTotals(ctx, "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE", "USD")
+	if err != nil {
+		t.Fatalf("getSpendTotals failed: %v", err)
+	}
+	if totals.Daily != want {
+		t.Errorf("daily spend = %v, want %v", totals.Daily, want)
+	}
+}
+
+func TestIsLegalTransition(t *testing.T) {
+	tests := []struct {
+		from  string
+		to    string
+		legal bool
+	}{
+		{TransactionInitiated, TransactionValidated, true},
+		{TransactionInitiated, TransactionFailed, true},
+		{TransactionInitiated, TransactionSubmitted, false},
+		{TransactionValidated, TransactionSubmitted, true},
+		{TransactionSubmitted, TransactionConfirmed, true},
+		{TransactionSubmitted, TransactionFailed, true},
+		{TransactionSubmitted, TransactionValidated, false},
+		{TransactionConfirmed, TransactionReversed, true},
+		{TransactionConfirmed, TransactionFailed, false},
+		{TransactionFailed, TransactionReversed, true},
+		{TransactionCompleted, TransactionReversed, true},
+		{TransactionCompleted, TransactionConfirmed, false},
+		{TransactionReversed, TransactionConfirmed, false},
+		{TransactionReversed, TransactionReversed, false},
+		{"UNKNOWN", TransactionValidated, false},
+	}
+	for _, test := range tests {
+		if legal := isLegalTransition(test.from, test.to); legal != test.legal {
+			t.Errorf("isLegalTransition(%s, %s) = %v, want %v", test.from, test.to, legal, test.legal)
+		}
+	}
+}
+
+func TestParseTransitionEvidence(t *testing.T) {
+	signature := testSolanaSignature(1)
+
+	tests := []struct {
+		evidence string
+		to       string
+		valid    bool
+	}{
+		{"", TransactionValidated, true},
+		{"", TransactionSubmitted, false},
+		{`{"solanaSignature":"` + signature + `"}`, TransactionSubmitted, true},
+		{`{"solanaSignature":"` + testSolanaAddress(1) + `"}`, TransactionSubmitted, false},
+		{`{"solanaSignature":"0OIl"}`, TransactionSubmitted, false},
+		{`{"slot":12345}`, TransactionConfirmed, true},
+		{`{"reason":"confirmed"}`, TransactionConfirmed, false},
+		{`{"reason":"insufficient funds"}`, TransactionFailed, true},
+		{`{"slot":12345}`, TransactionFailed, false},
+		{`{"reason":"chargeback"}`, TransactionReversed, true},
+		{"", TransactionReversed, false},
+		{`{"reason":"chargeback","refund":true}`, TransactionReversed, false},
+		{`{"slot":"12345"}`, TransactionConfirmed, false},
+	}
+	for _, test := range tests {
+		evidence, err := parseTransitionEvidence(test.evidence, test.to)
+		if (err == nil) != test.valid {
+			t.Errorf("parseTransitionEvidence(%s, %s) = %+v, %v, want valid %v", test.evidence, test.to, evidence, err, test.valid)
+		}
+	}
+}
+
+func TestTransitionTransaction(t *testing.T) {
+	ctx, _ := newLifecycleTestContext(t, "t1")
+	contract := new(SmartContract)
+	signature := testSolanaSignature(1)
+
+	steps := []struct {
+		status   string
+		evidence string
+	}{
+		{TransactionValidated, ""},
+		{TransactionSubmitted, `{"solanaSignature":"` + signature + `"}`},
+		{TransactionConfirmed, `{"slot":12345}`},
+	}
+	for _, step := range steps {
+		_, err := contract.TransitionTransaction(ctx, "t1", step.status, step.evidence)
+		if err != nil {
+			t.Fatalf("TransitionTransaction to %s failed: %v", step.status, err)
+		}
+	}
+
+	transaction, err := contract.GetTransaction(ctx, "t1")
+	if err != nil {
+		t.Fatalf("GetTransaction failed: %v", err)
+	}
+	if transaction.Status != TransactionConfirmed || transaction.SolanaSignature != signature || transaction.SolanaSlot != 12345 {
+		t.Fatalf("transaction = %+v, want it confirmed with the signature and slot", transaction)
+	}
+	if len(transaction.Transitions) != len(steps) {
+		t.Fatalf("transaction has %d transitions, want %d", len(transaction.Transitions), len(steps))
+	}
+	from := TransactionInitiated
+	for i, transition := range transaction.Transitions {
+		if transition.FromStatus != from || transition.ToStatus != steps[i].status ||
+			transition.ChangedByMSPID != "Org1MSP" || transition.TxID != "tx1" || transition.ChangedAt == "" {
+			t.Errorf("transition %d = %+v, want %s to %s by Org1MSP in tx1", i, transition, from, steps[i].status)
+		}
+		from = transition.ToStatus
+	}
+	checkTestDailySpend(t, ctx, 100)
+
+	if _, err = contract.TransitionTransaction(ctx, "t1", TransactionValidated, ""); err == nil {
+		t.Error("TransitionTransaction moved a confirmed transaction back to VALIDATED")
+	}
+	if _, err = contract.TransitionTransaction(ctx, "t2", TransactionValidated, ""); err == nil {
+		t.Error("TransitionTransaction moved a transaction that does not exist")
+	}
+	_, err = contract.TransitionTransaction(ctx, "t1", TransactionReversed,
+		`{"solanaSignature":"`+testSolanaSignature(2)+`","reason":"chargeback"}`)
+	if err == nil {
+		t.Error("TransitionTransaction accepted a different Solana signature")
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}})
+	if _, err = contract.TransitionTransaction(ctx, "t1", TransactionReversed, `{"reason":"chargeback"}`); err == nil {
+		t.Error("TransitionTransaction succeeded for a client that is not the bridge")
+	}
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org9MSP", attrs: map[string]string{"nivix.bridge": "true"}})
+	if _, err = contract.TransitionTransaction(ctx, "t1", TransactionReversed, `{"reason":"chargeback"}`); err == nil {
+		t.Error("TransitionTransaction succeeded for a bridge attribute outside the bridge MSPs")
+	}
+
+	// Reversing a confirmed transfer releases its spend
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	transaction, err = contract.TransitionTransaction(ctx, "t1", TransactionReversed, `{"reason":"chargeback"}`)
+	if err != nil {
+		t.Fatalf("TransitionTransaction to %s failed: %v", TransactionReversed, err)
+	}
+	if transaction.Transitions[len(transaction.Transitions)-1].Reason != "chargeback" {
+		t.Errorf("reversal transition = %+v, want the reason", transaction.Transitions[len(transaction.Transitions)-1])
+	}
+	checkTestDailySpend(t, ctx, 0)
+}
+
+func TestTransitionTransactionReleasesSpendOnce(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		release bool
+	}{
+		{TransactionInitiated, TransactionFailed, true},
+		{TransactionSubmitted, TransactionFailed, true},
+		{TransactionConfirmed, TransactionReversed, true},
+		{TransactionCompleted, TransactionReversed, true},
+		{TransactionFailed, TransactionReversed, false},
+		{TransactionSubmitted, TransactionConfirmed, false},
+	}
+	for _, test := range tests {
+		if release := releasesSpend(test.from, test.to); release != test.release {
+			t.Errorf("releasesSpend(%s, %s) = %v, want %v", test.from, test.to, release, test.release)
+		}
+	}
+
+	ctx, _ := newLifecycleTestContext(t, "t1")
+	contract := new(SmartContract)
+
+	_, err := contract.TransitionTransaction(ctx, "t1", TransactionFailed, `{"reason":"insufficient funds"}`)
+	if err != nil {
+		t.Fatalf("TransitionTransaction to %s failed: %v", TransactionFailed, err)
+	}
+	checkTestDailySpend(t, ctx, 0)
+	_, err = contract.TransitionTransaction(ctx, "t1", TransactionReversed, `{"reason":"refunded"}`)
+	if err != nil {
+		t.Fatalf("TransitionTransaction to %s failed: %v", TransactionReversed, err)
+	}
+	checkTestDailySpend(t, ctx, 0)
+
+	// Transactions recorded before spentAt existed have no spend to release
+	err = putTransaction(ctx, &TransactionRecord{TransactionID: "t0", FromAddress: "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		Amount: "40", SourceCurrency: "USD", Status: TransactionCompleted})
+	if err != nil {
+		t.Fatalf("putTransaction failed: %v", err)
+	}
+	_, err = contract.TransitionTransaction(ctx, "t0", TransactionReversed, `{"reason":"chargeback"}`)
+	if err != nil {
+		t.Fatalf("TransitionTransaction of a legacy transaction failed: %v", err)
+	}
+	checkTestDailySpend(t, ctx, 0)
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// kycSnapshotConfig holds the latest KYC Merkle snapshot
+const kycSnapshotConfig = "kycSnapshot"
+
+// kycSnapshotObjectType is the composite key object type of the KYC Merkle snapshot history
+const kycSnapshotObjectType = "kycSnapshot"
+
+// kycSnapshotBuildConfig holds the state of the KYC snapshot being committed
+const kycSnapshotBuildConfig = "kycSnapshotBuild"
+
+// kycSnapshotLeafObjectType is the composite key object type of the leaves of the latest snapshot
+const kycSnapshotLeafObjectType = "kycSnapshotLeaf~version~address"
+
+// kycSnapshotNodeObjectType is the composite key object type of the tree nodes of the latest
+// snapshot, leaf hashes being level 0
+const kycSnapshotNodeObjectType = "kycSnapshotNode~version~level~index"
+
+// Phases of a KYC snapshot commit
+const (
+	snapshotPhaseLeaves = "LEAVES"
+	snapshotPhaseTree   = "TREE"
+	snapshotPhasePrune  = "PRUNE"
+)
+
+// Domain separation prefixes of leaf and interior node hashes
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// KYCSnapshot is a Merkle commitment to the KYC status of every verified address
+type KYCSnapshot struct {
+	Version   int    `json:"version"`
+	Root      string `json:"root"`
+	LeafCount int    `json:"leafCount"`
+	CreatedAt string `json:"createdAt"`
+	TxID      string `json:"txId"`
+}
+
+// KYCLeaf is the committed KYC status of an address and its position in the tree
+type KYCLeaf struct {
+	Address    string `json:"address"`
+	Verified   bool   `json:"verified"`
+	TierLevel  int    `json:"tierLevel"`
+	ExpiryUnix int64  `json:"expiryUnix"`
+	Index      int    `json:"index"`
+}
+
+// SnapshotCommitResult reports a call of a KYC snapshot commit. Snapshot is set by the
+// call that publishes the new root.
+type SnapshotCommitResult struct {
+	Version  int          `json:"version"`
+	Phase    string       `json:"phase"`
+	Scanned  int          `json:"scanned"`
+	Snapshot *KYCSnapshot `json:"snapshot,omitempty"`
+	Bookmark string       `json:"bookmark"`
+}
+
+// kycSnapshotBuild is the state of a snapshot commit carried between calls. Frontier holds,
+// per tree level, the hash of the last node with an even index until its sibling is added.
+type kycSnapshotBuild struct {
+	Version           int      `json:"version"`
+	Phase             string   `json:"phase"`
+	NextKey           string   `json:"nextKey"`
+	LeafCount         int      `json:"leafCount"`
+	Frontier          []string `json:"frontier"`
+	PreviousVersion   int      `json:"previousVersion"`
+	PreviousLeafCount int      `json:"previousLeafCount"`
+}
+
+// ProofStep is a sibling hash on the path from a leaf to the Merkle root
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// KYCProof proves that a leaf is included in a KYC snapshot
+type KYCProof struct {
+	Leaf            *KYCLeaf     `json:"leaf"`
+	LeafHash        string       `json:"leafHash"`
+	Siblings        []*ProofStep `json:"siblings"`
+	Root            string       `json:"root"`
+	SnapshotVersion int          `json:"snapshotVersion"`
+}
+
+// hashLeaf hashes the committed status of an address as
+// SHA-256(0x00 || address public key || verified || tier level || expiry as little-endian int64)
+func hashLeaf(leaf *KYCLeaf, publicKey []byte) []byte {
+	data := make([]byte, 0, 1+len(publicKey)+1+1+8)
+	data = append(data, merkleLeafPrefix)
+	data = append(data, publicKey...)
+	if leaf.Verified {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	data = append(data, byte(leaf.TierLevel))
+	expiry := make([]byte, 8)
+	binary.LittleEndian.PutUint64(expiry, uint64(leaf.ExpiryUnix))
+	data = append(data, expiry...)
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// hashNode hashes two child nodes as SHA-256(0x01 || left || right)
+func hashNode(left []byte, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleNodePrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// recordKYCLeaves builds the leaves of every active address of a public KYC record.
+// Linked addresses share the status of their user's record; addresses that are not valid
+// Solana public keys cannot be checked on Solana and are left out.
+func recordKYCLeaves(ctx contractapi.TransactionContextInterface, publicRecord *PublicKYCRecord) ([]*KYCLeaf, error) {
+	userId, solanaAddress := publicRecord.UserID, publicRecord.SolanaAddress
+	screeningStatus := publicRecord.ScreeningStatus
+
+	tier := publicRecord.kycRecord().Tier
+	leaf := KYCLeaf{
+		Verified:  tier != TierNone && screeningStatus != ScreeningHeld && screeningStatus != ScreeningBlocked,
+		TierLevel: tierLevels[tier],
+	}
+	if expiry, err := time.Parse(dateLayout, publicRecord.ExpiryDate); err == nil {
+		leaf.ExpiryUnix = expiry.Unix()
+	}
+
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{solanaAddress}
+	for _, link := range links {
+		if link.Status == AddressActive && link.Address != solanaAddress {
+			addresses = append(addresses, link.Address)
+		}
+	}
+
+	leaves := []*KYCLeaf{}
+	for _, address := range addresses {
+		if _, err := decodeSolanaAddress(address); err != nil {
+			continue
+		}
+		addressLeaf := leaf
+		addressLeaf.Address = address
+		leaves = append(leaves, &addressLeaf)
+	}
+
+	return leaves, nil
+}
+
+// snapshotLeafKey builds the composite key of a snapshot leaf
+func snapshotLeafKey(ctx contractapi.TransactionContextInterface, version int, address string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(kycSnapshotLeafObjectType, []string{fmt.Sprintf("%010d", version), address})
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot leaf key: %v", err)
+	}
+
+	return key, nil
+}
+
+// snapshotNodeKey builds the composite key of a tree node of a snapshot
+func snapshotNodeKey(ctx contractapi.TransactionContextInterface, version int, level int, index int) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(kycSnapshotNodeObjectType,
+		[]string{fmt.Sprintf("%010d", version), fmt.Sprintf("%02d", level), fmt.Sprintf("%010d", index)})
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot node key: %v", err)
+	}
+
+	return key, nil
+}
+
+// bookmark returns the bookmark of the next call of the snapshot commit, or an empty
+// string when no snapshot is being committed
+func (build *kycSnapshotBuild) bookmark() string {
+	if build.Phase == "" {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(build.Phase + build.NextKey))
+}
+
+// addNode stores a tree node and every parent it completes. A node with an even index
+// waits in the frontier until its right sibling is added.
+func (build *kycSnapshotBuild) addNode(ctx contractapi.TransactionContextInterface, level int, index int, hash []byte) error {
+	for {
+		key, err := snapshotNodeKey(ctx, build.Version, level, index)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(key, hash)
+		if err != nil {
+			return fmt.Errorf("failed to put snapshot node: %v", err)
+		}
+
+		for len(build.Frontier) <= level {
+			build.Frontier = append(build.Frontier, "")
+		}
+		if index%2 == 0 {
+			build.Frontier[level] = hex.EncodeToString(hash)
+			return nil
+		}
+
+		left, err := hex.DecodeString(build.Frontier[level])
+		if err != nil {
+			return fmt.Errorf("invalid snapshot frontier: %v", err)
+		}
+		build.Frontier[level] = ""
+		hash = hashNode(left, hash)
+		level++
+		index /= 2
+	}
+}
+
+// finishTree carries the last node of each level without a sibling up to the next level
+// and returns the Merkle root, or the hash of nothing for an empty tree
+func (build *kycSnapshotBuild) finishTree(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	if build.LeafCount == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:], nil
+	}
+
+	level := 0
+	for count := build.LeafCount; count > 1; count = (count + 1) / 2 {
+		if count%2 == 1 {
+			carried, err := hex.DecodeString(build.Frontier[level])
+			if err != nil {
+				return nil, fmt.Errorf("invalid snapshot frontier: %v", err)
+			}
+			build.Frontier[level] = ""
+			err = build.addNode(ctx, level+1, (count-1)/2, carried)
+			if err != nil {
+				return nil, err
+			}
+		}
+		level++
+	}
+
+	root, err := hex.DecodeString(build.Frontier[level])
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot frontier: %v", err)
+	}
+
+	return root, nil
+}
+
+// writeLeaves writes the leaves of a page of public KYC records
+func (build *kycSnapshotBuild) writeLeaves(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	result *SnapshotCommitResult) error {
+
+	entries, nextKey, err := scanObjectType(ctx, kycObjectType, build.NextKey, pageSize)
+	if err != nil {
+		return err
+	}
+
+	result.Scanned = len(entries)
+	for _, entry := range entries {
+		publicRecord, err := decodePublicKYCRecord(entry.Value)
+		if err != nil {
+			continue
+		}
+		leaves, err := recordKYCLeaves(ctx, publicRecord)
+		if err != nil {
+			return err
+		}
+
+		for _, leaf := range leaves {
+			key, err := snapshotLeafKey(ctx, build.Version, leaf.Address)
+			if err != nil {
+				return err
+			}
+			leafJSON, err := json.Marshal(leaf)
+			if err != nil {
+				return err
+			}
+			err = ctx.GetStub().PutState(key, leafJSON)
+			if err != nil {
+				return fmt.Errorf("failed to put snapshot leaf: %v", err)
+			}
+		}
+	}
+
+	build.NextKey = nextKey
+	if nextKey == "" {
+		build.Phase = snapshotPhaseTree
+	}
+
+	return nil
+}
+
+// hashLeaves numbers a page of the written leaves in key order and adds their hashes to
+// the tree. After the last page the snapshot is published.
+func (build *kycSnapshotBuild) hashLeaves(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	result *SnapshotCommitResult) error {
+
+	entries, nextKey, err := scanPartialCompositeKey(ctx, kycSnapshotLeafObjectType,
+		[]string{fmt.Sprintf("%010d", build.Version)}, build.NextKey, pageSize)
+	if err != nil {
+		return err
+	}
+
+	result.Scanned = len(entries)
+	for _, entry := range entries {
+		var leaf KYCLeaf
+		err = json.Unmarshal(entry.Value, &leaf)
+		if err != nil {
+			return err
+		}
+		publicKey, err := decodeSolanaAddress(leaf.Address)
+		if err != nil {
+			return err
+		}
+
+		leaf.Index = build.LeafCount
+		leafJSON, err := json.Marshal(&leaf)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(entry.Key, leafJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put snapshot leaf: %v", err)
+		}
+
+		err = build.addNode(ctx, 0, leaf.Index, hashLeaf(&leaf, publicKey))
+		if err != nil {
+			return err
+		}
+		build.LeafCount++
+	}
+
+	build.NextKey = nextKey
+	if nextKey != "" {
+		return nil
+	}
+
+	root, err := build.finishTree(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	snapshot := &KYCSnapshot{
+		Version:   build.Version,
+		Root:      hex.EncodeToString(root),
+		LeafCount: build.LeafCount,
+		CreatedAt: now.Format(time.RFC3339),
+		TxID:      ctx.GetStub().GetTxID(),
+	}
+
+	historyKey, err := ctx.GetStub().CreateCompositeKey(kycSnapshotObjectType, []string{fmt.Sprintf("%010d", snapshot.Version)})
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot key: %v", err)
+	}
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(historyKey, snapshotJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put snapshot: %v", err)
+	}
+	err = putConfig(ctx, kycSnapshotConfig, snapshot)
+	if err != nil {
+		return err
+	}
+
+	result.Snapshot = snapshot
+	build.Frontier = nil
+	build.Phase = ""
+	if build.PreviousVersion > 0 {
+		build.Phase = snapshotPhasePrune
+	}
+
+	return nil
+}
+
+// pruneLeaves deletes a page of the leaves of the previous snapshot together with the tree
+// nodes whose leftmost leaf they are, which covers every node exactly once
+func (build *kycSnapshotBuild) pruneLeaves(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	result *SnapshotCommitResult) error {
+
+	entries, nextKey, err := scanPartialCompositeKey(ctx, kycSnapshotLeafObjectType,
+		[]string{fmt.Sprintf("%010d", build.PreviousVersion)}, build.NextKey, pageSize)
+	if err != nil {
+		return err
+	}
+
+	result.Scanned = len(entries)
+	for _, entry := range entries {
+		var leaf KYCLeaf
+		err = json.Unmarshal(entry.Value, &leaf)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(entry.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete snapshot leaf: %v", err)
+		}
+
+		position, count := leaf.Index, build.PreviousLeafCount
+		for level := 0; ; level++ {
+			key, err := snapshotNodeKey(ctx, build.PreviousVersion, level, position)
+			if err != nil {
+				return err
+			}
+			err = ctx.GetStub().DelState(key)
+			if err != nil {
+				return fmt.Errorf("failed to delete snapshot node: %v", err)
+			}
+			if position%2 == 1 || count <= 1 {
+				break
+			}
+			position /= 2
+			count = (count + 1) / 2
+		}
+	}
+
+	build.NextKey = nextKey
+	if nextKey == "" {
+		build.Phase = ""
+	}
+
+	return nil
+}
+
+// getKYCSnapshot reads the latest KYC snapshot
+func getKYCSnapshot(ctx contractapi.TransactionContextInterface) (*KYCSnapshot, error) {
+	var snapshot KYCSnapshot
+	found, err := getConfig(ctx, kycSnapshotConfig, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no KYC snapshot has been committed")
+	}
+
+	return &snapshot, nil
+}
+
+// CommitKYCSnapshot commits the current KYC status of every active address to a new Merkle
+// snapshot, whose root the bridge posts to Solana. The commit runs over several calls: each
+// call handles up to pageSize entries and returns a bookmark to pass to the next call until
+// it is empty. The first calls write a leaf per address of the public KYC records, the next
+// ones hash the leaves into the tree and publish the snapshot, and the last ones delete the
+// leaves and tree nodes of the previous snapshot. Each record is committed as it was when
+// its page was read. Records still stored under simple keys are only included once
+// MigrateKeyspace has moved them. A new commit cannot start while one is in progress.
+func (s *SmartContract) CommitKYCSnapshot(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	bookmark string) (*SnapshotCommitResult, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	build := &kycSnapshotBuild{}
+	_, err = getConfig(ctx, kycSnapshotBuildConfig, build)
+	if err != nil {
+		return nil, err
+	}
+	if bookmark != build.bookmark() {
+		if build.Phase == "" {
+			return nil, fmt.Errorf("invalid bookmark: no KYC snapshot is being committed")
+		}
+		return nil, fmt.Errorf("KYC snapshot %d is being committed, resume it with bookmark %s", build.Version, build.bookmark())
+	}
+
+	if build.Phase == "" {
+		previous := &KYCSnapshot{}
+		_, err = getConfig(ctx, kycSnapshotConfig, previous)
+		if err != nil {
+			return nil, err
+		}
+		build = &kycSnapshotBuild{
+			Version:           previous.Version + 1,
+			Phase:             snapshotPhaseLeaves,
+			PreviousVersion:   previous.Version,
+			PreviousLeafCount: previous.LeafCount,
+		}
+	}
+
+	result := &SnapshotCommitResult{
+		Version: build.Version,
+		Phase:   build.Phase,
+	}
+	switch build.Phase {
+	case snapshotPhaseLeaves:
+		err = build.writeLeaves(ctx, pageSize, result)
+	case snapshotPhaseTree:
+		err = build.hashLeaves(ctx, pageSize, result)
+	case snapshotPhasePrune:
+		err = build.pruneLeaves(ctx, pageSize, result)
+	default:
+		err = fmt.Errorf("unknown KYC snapshot phase %s", build.Phase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = putConfig(ctx, kycSnapshotBuildConfig, build)
+	if err != nil {
+		return nil, err
+	}
+	result.Bookmark = build.bookmark()
+
+	return result, nil
+}
+
+// GetKYCRoot returns the latest KYC snapshot and its Merkle root
+func (s *SmartContract) GetKYCRoot(ctx contractapi.TransactionContextInterface) (*KYCSnapshot, error) {
+	return getKYCSnapshot(ctx)
+}
+
+// GetKYCProof returns the inclusion proof of an address in the latest KYC snapshot. The
+// proof reads the address's leaf and one stored sibling node per tree level.
+func (s *SmartContract) GetKYCProof(ctx contractapi.TransactionContextInterface,
+	solanaAddress string) (*KYCProof, error) {
+
+	snapshot, err := getKYCSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := decodeSolanaAddress(solanaAddress)
+	if err != nil {
+		return nil, err
+	}
+	key, err := snapshotLeafKey(ctx, snapshot.Version, solanaAddress)
+	if err != nil {
+		return nil, err
+	}
+	leafJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot leaf: %v", err)
+	}
+	if leafJSON == nil {
+		return nil, fmt.Errorf("address %s is not included in KYC snapshot %d", solanaAddress, snapshot.Version)
+	}
+	var leaf KYCLeaf
+	err = json.Unmarshal(leafJSON, &leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &KYCProof{
+		Leaf:            &leaf,
+		LeafHash:        hex.EncodeToString(hashLeaf(&leaf, publicKey)),
+		Siblings:        []*ProofStep{},
+		Root:            snapshot.Root,
+		SnapshotVersion: snapshot.Version,
+	}
+	position, count := leaf.Index, snapshot.LeafCount
+	for level := 0; count > 1; level++ {
+		sibling := position ^ 1
+		if sibling < count {
+			key, err := snapshotNodeKey(ctx, snapshot.Version, level, sibling)
+			if err != nil {
+				return nil, err
+			}
+			hash, err := ctx.GetStub().GetState(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read snapshot node: %v", err)
+			}
+			if hash == nil {
+				return nil, fmt.Errorf("KYC snapshot %d has no node %d at level %d", snapshot.Version, sibling, level)
+			}
+			proof.Siblings = append(proof.Siblings, &ProofStep{
+				Hash: hex.EncodeToString(hash),
+				Left: sibling < position,
+			})
+		}
+		position /= 2
+		count = (count + 1) / 2
+	}
+
+	return proof, nil
+}
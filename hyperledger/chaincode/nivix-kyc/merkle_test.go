@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// testBase58 encodes bytes in the Bitcoin base58 alphabet used by Solana
+func testBase58(value []byte) string {
+	const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	number := new(big.Int).SetBytes(value)
+	radix := big.NewInt(58)
+	encoded := ""
+	for number.Sign() > 0 {
+		remainder := new(big.Int)
+		number.DivMod(number, radix, remainder)
+		encoded = string(alphabet[remainder.Int64()]) + encoded
+	}
+	for _, b := range value {
+		if b != 0 {
+			break
+		}
+		encoded = "1" + encoded
+	}
+
+	return encoded
+}
+
+// testSolanaAddress encodes a 32-byte public key derived from seed as a Solana address
+func testSolanaAddress(seed int) string {
+	publicKey := sha256.Sum256([]byte(fmt.Sprint(seed)))
+	return testBase58(publicKey[:])
+}
+
+// newMerkleTestContext starts an admin transaction with count public KYC records, every
+// third of them unverified, and returns their addresses
+func newMerkleTestContext(t *testing.T, count int) (*TransactionContext, *levelDBMockStub, []string) {
+	stub := &levelDBMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	stub.MockTransactionStart("tx1")
+
+	addresses := []string{}
+	for i := 0; i < count; i++ {
+		record := &PublicKYCRecord{
+			UserID:        fmt.Sprintf("user%d", i),
+			SolanaAddress: testSolanaAddress(i),
+			KYCVerified:   i%3 != 0,
+			Tier:          TierIDVerified,
+			ExpiryDate:    "2026-06-01",
+		}
+		if !record.KYCVerified {
+			record.Tier = TierNone
+		}
+		err := putPublicKYCRecord(ctx, record)
+		if err != nil {
+			t.Fatalf("putPublicKYCRecord failed: %v", err)
+		}
+		addresses = append(addresses, record.SolanaAddress)
+	}
+	sort.Strings(addresses)
+
+	return ctx, stub, addresses
+}
+
+// commitTestSnapshot runs a snapshot commit to the end and returns the published snapshot
+func commitTestSnapshot(t *testing.T, ctx *TransactionContext, pageSize int) *KYCSnapshot {
+	contract := new(SmartContract)
+
+	var snapshot *KYCSnapshot
+	bookmark := ""
+	for calls := 0; ; calls++ {
+		if calls > 100 {
+			t.Fatal("CommitKYCSnapshot did not finish")
+		}
+		result, err := contract.CommitKYCSnapshot(ctx, pageSize, bookmark)
+		if err != nil {
+			t.Fatalf("CommitKYCSnapshot failed: %v", err)
+		}
+		if result.Scanned > pageSize {
+			t.Fatalf("CommitKYCSnapshot scanned %d entries, want at most %d", result.Scanned, pageSize)
+		}
+		if result.Snapshot != nil {
+			snapshot = result.Snapshot
+		}
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if snapshot == nil {
+		t.Fatal("CommitKYCSnapshot finished without publishing a snapshot")
+	}
+
+	return snapshot
+}
+
+// testMerkleRoot computes the root of the leaf hashes level by level, carrying a node
+// without a sibling up unchanged
+func testMerkleRoot(level [][]byte) []byte {
+	if len(level) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	}
+	for len(level) > 1 {
+		next := [][]byte{}
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, hashNode(level[i], level[i+1]))
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+func TestCommitKYCSnapshotProofs(t *testing.T) {
+	for _, count := range []int{0, 1, 2, 3, 5, 8, 13} {
+		ctx, _, addresses := newMerkleTestContext(t, count)
+		contract := new(SmartContract)
+
+		snapshot := commitTestSnapshot(t, ctx, 2)
+		if snapshot.Version != 1 || snapshot.LeafCount != count {
+			t.Fatalf("%d records: snapshot version %d with %d leaves, want version 1 with %d leaves",
+				count, snapshot.Version, snapshot.LeafCount, count)
+		}
+
+		leafHashes := [][]byte{}
+		for i, address := range addresses {
+			proof, err := contract.GetKYCProof(ctx, address)
+			if err != nil {
+				t.Fatalf("%d records: GetKYCProof failed: %v", count, err)
+			}
+			if proof.Leaf.Index != i {
+				t.Errorf("%d records: leaf %s has index %d, want %d", count, address, proof.Leaf.Index, i)
+			}
+			if len(proof.Siblings) > 4 {
+				t.Errorf("%d records: proof has %d siblings", count, len(proof.Siblings))
+			}
+
+			hash, _ := hex.DecodeString(proof.LeafHash)
+			leafHashes = append(leafHashes, hash)
+			for _, step := range proof.Siblings {
+				sibling, _ := hex.DecodeString(step.Hash)
+				if step.Left {
+					hash = hashNode(sibling, hash)
+				} else {
+					hash = hashNode(hash, sibling)
+				}
+			}
+			if hex.EncodeToString(hash) != snapshot.Root {
+				t.Errorf("%d records: proof of %s does not lead to the root", count, address)
+			}
+		}
+
+		if root := hex.EncodeToString(testMerkleRoot(leafHashes)); root != snapshot.Root {
+			t.Errorf("%d records: root %s, want %s", count, snapshot.Root, root)
+		}
+	}
+}
+
+func TestCommitKYCSnapshotPrunesPreviousSnapshot(t *testing.T) {
+	ctx, stub, addresses := newMerkleTestContext(t, 5)
+	contract := new(SmartContract)
+
+	first := commitTestSnapshot(t, ctx, 3)
+	err := putPublicKYCRecord(ctx, &PublicKYCRecord{UserID: "user5", SolanaAddress: testSolanaAddress(5), Tier: TierNone})
+	if err != nil {
+		t.Fatalf("putPublicKYCRecord failed: %v", err)
+	}
+	second := commitTestSnapshot(t, ctx, 3)
+	if second.Version != 2 || second.LeafCount != 6 || second.Root == first.Root {
+		t.Fatalf("second snapshot = %+v, want version 2 with 6 leaves and a new root", second)
+	}
+
+	// Version 2 has 6 leaves and 6 interior nodes
+	keys := 0
+	for key := range stub.State {
+		for _, objectType := range []string{kycSnapshotLeafObjectType, kycSnapshotNodeObjectType} {
+			if strings.HasPrefix(key, "\x00"+objectType+"\x00") {
+				if !strings.HasPrefix(key, "\x00"+objectType+"\x000000000002\x00") {
+					t.Errorf("key %q of the previous snapshot was not deleted", key)
+				}
+				keys++
+			}
+		}
+	}
+	if keys != 6+6+6 {
+		t.Errorf("snapshot has %d leaf and node keys, want %d", keys, 6+6+6)
+	}
+
+	_, err = contract.GetKYCProof(ctx, addresses[0])
+	if err != nil {
+		t.Fatalf("GetKYCProof failed: %v", err)
+	}
+	_, err = contract.GetKYCProof(ctx, testSolanaAddress(99))
+	if err == nil {
+		t.Fatal("GetKYCProof succeeded for an address outside the snapshot")
+	}
+}
+
+func TestCommitKYCSnapshotBookmark(t *testing.T) {
+	ctx, _, _ := newMerkleTestContext(t, 3)
+	contract := new(SmartContract)
+
+	_, err := contract.CommitKYCSnapshot(ctx, 1, "TEVBVkVT")
+	if err == nil {
+		t.Fatal("CommitKYCSnapshot accepted a bookmark without a commit in progress")
+	}
+
+	result, err := contract.CommitKYCSnapshot(ctx, 1, "")
+	if err != nil {
+		t.Fatalf("CommitKYCSnapshot failed: %v", err)
+	}
+	if result.Bookmark == "" || result.Phase != snapshotPhaseLeaves {
+		t.Fatalf("first call = %+v, want a bookmark in phase %s", result, snapshotPhaseLeaves)
+	}
+
+	// A second commit cannot start while the first one is in progress
+	_, err = contract.CommitKYCSnapshot(ctx, 1, "")
+	if err == nil || !strings.Contains(err.Error(), result.Bookmark) {
+		t.Fatalf("CommitKYCSnapshot without the bookmark = %v, want an error naming the bookmark", err)
+	}
+	_, err = contract.GetKYCRoot(ctx)
+	if err == nil {
+		t.Fatal("GetKYCRoot returned a snapshot that is not published yet")
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}})
+	_, err = contract.CommitKYCSnapshot(ctx, 1, result.Bookmark)
+	if err == nil {
+		t.Fatal("CommitKYCSnapshot succeeded for a non-admin client")
+	}
+}
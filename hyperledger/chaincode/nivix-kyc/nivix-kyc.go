@@ -1,22 +1,39 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
+// currentKYCAPIVersion is the newest KYC API version supported by the chaincode
+const currentKYCAPIVersion = 2
+
+// countryCodePattern matches ISO 3166-1 alpha-2 country codes
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
 // KYCRecord represents a KYC record
 type KYCRecord struct {
-	UserID           string `json:"userId"`
-	SolanaAddress    string `json:"solanaAddress"`
-	FullName         string `json:"fullName"`
-	KYCVerified      bool   `json:"kycVerified"`
-	VerificationDate string `json:"verificationDate"`
-	RiskScore        int    `json:"riskScore"`
-	CountryCode      string `json:"countryCode"`
+	UserID           string   `json:"userId"`
+	SolanaAddress    string   `json:"solanaAddress"`
+	FullName         string   `json:"fullName"`
+	KYCVerified      bool     `json:"kycVerified"`
+	VerificationDate string   `json:"verificationDate"`
+	RiskScore        int      `json:"riskScore"`
+	CountryCode      string   `json:"countryCode"`
+	DateOfBirth      string   `json:"dateOfBirth,omitempty"`
+	ScreeningStatus  string   `json:"screeningStatus,omitempty"`
+	ClearedMatches   []string `json:"clearedMatches,omitempty"`
+	ExpiryDate       string   `json:"expiryDate,omitempty"`
+	ExpiryStatus     string   `json:"expiryStatus,omitempty"`
+	Tier             string   `json:"tier,omitempty"`
 }
 
 // ComplianceRecord represents a compliance record
@@ -25,33 +42,50 @@ type ComplianceRecord struct {
 	Action      string `json:"action"`
 	Description string `json:"description"`
 	Timestamp   string `json:"timestamp"`
+	TxID        string `json:"txId"`
+	Sequence    int    `json:"sequence"`
 }
 
 // TransactionValidation represents a transaction validation request
 type TransactionValidation struct {
-	TransactionID string  `json:"transactionId"`
-	Amount        float64 `json:"amount"`
-	Currency      string  `json:"currency"`
-	Destination   string  `json:"destination"`
+	TransactionID      string   `json:"transactionId"`
+	Amount             float64  `json:"amount"`
+	Currency           string   `json:"currency"`
+	Destination        string   `json:"destination"`
+	DestinationCountry string   `json:"destinationCountry,omitempty"`
+	BeneficiaryName    string   `json:"beneficiaryName,omitempty"`
+	WalletBalance      *float64 `json:"walletBalance,omitempty"`
 }
 
 // ValidationResult represents the result of a transaction validation
 type ValidationResult struct {
 	IsValid bool   `json:"isValid"`
 	Message string `json:"message"`
+	RuleID  string `json:"ruleId,omitempty"`
+	Status  string `json:"status,omitempty"`
+
+	TravelRuleRequired bool `json:"travelRuleRequired,omitempty"`
 }
 
 // TransactionRecord represents a transaction record
 type TransactionRecord struct {
-	TransactionID       string `json:"transactionId"`
-	FromAddress         string `json:"fromAddress"`
-	ToAddress           string `json:"toAddress"`
-	Amount              string `json:"amount"`
-	SourceCurrency      string `json:"sourceCurrency"`
-	DestinationCurrency string `json:"destinationCurrency"`
-	Memo                string `json:"memo"`
-	Timestamp           string `json:"timestamp"`
-	Status              string `json:"status"`
+	DocType             string                   `json:"docType,omitempty"`
+	TransactionID       string                   `json:"transactionId"`
+	FromAddress         string                   `json:"fromAddress"`
+	ToAddress           string                   `json:"toAddress"`
+	Amount              string                   `json:"amount"`
+	AmountValue         float64                  `json:"amountValue,omitempty"`
+	SourceCurrency      string                   `json:"sourceCurrency"`
+	DestinationCurrency string                   `json:"destinationCurrency"`
+	Memo                string                   `json:"memo"`
+	Timestamp           string                   `json:"timestamp"`
+	HistoryTimestamp    string                   `json:"historyTimestamp,omitempty"`
+	SpentAt             string                   `json:"spentAt,omitempty"`
+	Status              string                   `json:"status"`
+	ContentHash         string                   `json:"contentHash,omitempty"`
+	SolanaSignature     string                   `json:"solanaSignature,omitempty"`
+	SolanaSlot          uint64                   `json:"solanaSlot,omitempty"`
+	Transitions         []*TransactionTransition `json:"transitions,omitempty"`
 }
 
 // SmartContract provides functions for managing KYC data
@@ -59,6 +93,33 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+// TransactionContext is the transaction context used by the KYC chaincode.
+// A new context is created for every transaction, so it carries state that
+// must not outlive a single invocation.
+type TransactionContext struct {
+	contractapi.TransactionContext
+	complianceSequence int
+	events             []*ChaincodeEvent
+}
+
+// nextComplianceSequence returns the sequence number of the next compliance event in this transaction
+func (ctx *TransactionContext) nextComplianceSequence() int {
+	sequence := ctx.complianceSequence
+	ctx.complianceSequence++
+	return sequence
+}
+
+// txTimestamp returns the transaction timestamp chosen by the client, which is
+// identical on every endorsing peer unlike the peer's local clock
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
 // InitLedger initializes the ledger with sample data
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	fmt.Println("Initializing the ledger")
@@ -66,6 +127,13 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 }
 
 // StoreKYC stores KYC data in the ledger
+//
+// Deprecated: the personal data passed as arguments is recorded in the transaction
+// proposal and therefore in the block. Use StoreKYCPrivate instead. StoreKYC is
+// refused once the KYC API version is set to 2 or higher. Like StoreKYCPrivate, it may only
+// be called by the bridge, cannot change the primary address of an existing user and needs
+// a WalletOwnershipProof in the "wallet_proof" key of the transient map for an address not
+// yet linked to the user.
 func (s *SmartContract) StoreKYC(ctx contractapi.TransactionContextInterface,
 	userId string,
 	solanaAddress string,
@@ -75,6 +143,30 @@ func (s *SmartContract) StoreKYC(ctx contractapi.TransactionContextInterface,
 	riskScore int,
 	countryCode string) error {
 
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	version, err := getKYCAPIVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version >= 2 {
+		return fmt.Errorf("StoreKYC is not available from KYC API version 2, submit KYC data through StoreKYCPrivate")
+	}
+	if _, err := time.Parse(time.RFC3339, verificationDate); err != nil {
+		return fmt.Errorf("verificationDate must be an RFC3339 timestamp")
+	}
+	err = checkPrimaryAddressUnchanged(ctx, userId, solanaAddress)
+	if err != nil {
+		return err
+	}
+	err = requireWalletProof(ctx, userId, solanaAddress)
+	if err != nil {
+		return err
+	}
+
 	// Create KYC record
 	kycRecord := KYCRecord{
 		UserID:           userId,
@@ -86,162 +178,427 @@ func (s *SmartContract) StoreKYC(ctx contractapi.TransactionContextInterface,
 		CountryCode:      countryCode,
 	}
 
-	// Convert to JSON
-	kycJSON, err := json.Marshal(kycRecord)
+	err = assignKYCTier(ctx, &kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = s.screenKYCRecord(ctx, &kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = s.putKYCRecord(ctx, &kycRecord)
+	if err != nil {
+		return err
+	}
+
+	return emitKYCEvent(ctx, EventKYCStored, &kycRecord)
+}
+
+// StoreKYCPrivate stores KYC data in the ledger. The KYC record is read from the
+// "kyc_properties" key of the transient map so that personal data never appears
+// in the transaction proposal or the block. A record for an address not yet linked to
+// the user also needs a WalletOwnershipProof in the "wallet_proof" key of the transient map.
+// Only the bridge may store KYC data, and an existing user's primary address only changes
+// through SetPrimaryAddress.
+func (s *SmartContract) StoreKYCPrivate(ctx contractapi.TransactionContextInterface) error {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Get KYC record from transient map
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+
+	transientKYCJSON, ok := transientMap["kyc_properties"]
+	if !ok {
+		return fmt.Errorf("kyc_properties not found in the transient map input")
+	}
+
+	kycRecord, err := parseKYCInput(transientKYCJSON)
+	if err != nil {
+		return err
+	}
+
+	err = checkPrimaryAddressUnchanged(ctx, kycRecord.UserID, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
+
+	err = requireWalletProof(ctx, kycRecord.UserID, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
+
+	err = assignKYCTier(ctx, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = s.screenKYCRecord(ctx, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = s.putKYCRecord(ctx, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	return emitKYCEvent(ctx, EventKYCStored, kycRecord)
+}
+
+// parseKYCInput decodes a KYC record from transient input and validates its fields
+func parseKYCInput(kycJSON []byte) (*KYCRecord, error) {
+	decoder := json.NewDecoder(bytes.NewReader(kycJSON))
+	decoder.DisallowUnknownFields()
+
+	var kycRecord KYCRecord
+	err := decoder.Decode(&kycRecord)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal KYC JSON: %v", err)
+	}
+
+	if len(kycRecord.UserID) == 0 {
+		return nil, fmt.Errorf("userId field must be a non-empty string")
+	}
+	if len(kycRecord.SolanaAddress) == 0 {
+		return nil, fmt.Errorf("solanaAddress field must be a non-empty string")
+	}
+	if len(strings.TrimSpace(kycRecord.FullName)) == 0 {
+		return nil, fmt.Errorf("fullName field must be a non-empty string")
+	}
+	if _, err := time.Parse(time.RFC3339, kycRecord.VerificationDate); err != nil {
+		return nil, fmt.Errorf("verificationDate field must be an RFC3339 timestamp")
+	}
+	if kycRecord.RiskScore < 0 || kycRecord.RiskScore > 100 {
+		return nil, fmt.Errorf("riskScore field must be between 0 and 100")
+	}
+	if !countryCodePattern.MatchString(kycRecord.CountryCode) {
+		return nil, fmt.Errorf("countryCode field must be an ISO 3166-1 alpha-2 code")
+	}
+	if kycRecord.DateOfBirth != "" {
+		if _, err := time.Parse("2006-01-02", kycRecord.DateOfBirth); err != nil {
+			return nil, fmt.Errorf("dateOfBirth field must be a YYYY-MM-DD date")
+		}
+	}
+
+	// The tier is assigned by the chaincode, only admins change it
+	kycRecord.Tier = ""
+	if kycRecord.ScreeningStatus != "" || kycRecord.ClearedMatches != nil || kycRecord.ExpiryDate != "" || kycRecord.ExpiryStatus != "" {
+		return nil, fmt.Errorf("screeningStatus, clearedMatches, expiryDate and expiryStatus fields are set by the chaincode")
+	}
+
+	return &kycRecord, nil
+}
+
+// putKYCRecord writes the KYC record to the private collection and its public reference to the world state
+func (s *SmartContract) putKYCRecord(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord) error {
+
+	// The erasure tombstone, and a sanctions block it carries, must never be overwritten
+	err := checkNotErased(ctx, kycRecord.UserID, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
+
+	// Expiry is derived from the verification date whenever the record is read
+	storedRecord := *kycRecord
+	storedRecord.ExpiryDate = ""
+	storedRecord.ExpiryStatus = ""
+
+	// Convert to JSON, encrypted with the user's data key when encryption is in use
+	kycJSON, err := encodeKYCRecord(ctx, &storedRecord)
 	if err != nil {
 		return err
 	}
 
 	// Store in private data collection
-	err = ctx.GetStub().PutPrivateData("kycPrivateData", userId, kycJSON)
+	err = ctx.GetStub().PutPrivateData("kycPrivateData", kycRecord.UserID, kycJSON)
 	if err != nil {
 		return fmt.Errorf("failed to put KYC data: %v", err)
 	}
+	err = putKYCRecordDigest(ctx, &storedRecord)
+	if err != nil {
+		return err
+	}
+
+	// The public reference lives under the user's primary address
+	err = setPrimaryAddressLink(ctx, kycRecord.UserID, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
 
 	// Also store a public reference that this user has KYC
-	publicData := map[string]interface{}{
-		"userId":        userId,
-		"solanaAddress": solanaAddress,
-		"kycVerified":   kycVerified,
-		"riskScore":     riskScore,
-		"countryCode":   countryCode,
+	publicRecord := &PublicKYCRecord{
+		UserID:          kycRecord.UserID,
+		SolanaAddress:   kycRecord.SolanaAddress,
+		KYCVerified:     kycRecord.KYCVerified,
+		RiskScore:       kycRecord.RiskScore,
+		CountryCode:     kycRecord.CountryCode,
+		Tier:            effectiveTier(kycRecord),
+		ScreeningStatus: kycRecord.ScreeningStatus,
+	}
+
+	// Carry over the previous review date so that its index entry is replaced, and move
+	// the country index entry if the country changed
+	existingRecord, err := readPublicKYCRecord(ctx, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
+	if existingRecord != nil {
+		publicRecord.ReviewDate = existingRecord.ReviewDate
+		if existingRecord.CountryCode != "" && existingRecord.CountryCode != kycRecord.CountryCode {
+			err = deleteCountryIndexEntry(ctx, existingRecord.CountryCode, kycRecord.SolanaAddress)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	publicJSON, err := json.Marshal(publicData)
+
+	err = setReviewSchedule(ctx, publicRecord, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = putCountryIndexEntry(ctx, kycRecord.CountryCode, kycRecord.SolanaAddress)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(solanaAddress, publicJSON)
+	return putPublicKYCRecord(ctx, publicRecord)
 }
 
 // GetKYCStatus quickly checks if a Solana address has KYC verification
 func (s *SmartContract) GetKYCStatus(ctx contractapi.TransactionContextInterface,
 	solanaAddress string) (*KYCRecord, error) {
 	
-	kycBytes, err := ctx.GetStub().GetState(solanaAddress)
+	// Linked addresses resolve to the record under the user's primary address
+	primaryAddress, err := resolveKYCAddress(ctx, solanaAddress)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read KYC status: %v", err)
-	}
-	if kycBytes == nil {
-		return nil, fmt.Errorf("no KYC record found for address %s", solanaAddress)
+		return nil, err
 	}
 
-	var publicData map[string]interface{}
-	err = json.Unmarshal(kycBytes, &publicData)
+	publicRecord, err := readPublicKYCRecord(ctx, primaryAddress)
 	if err != nil {
 		return nil, err
 	}
+	if publicRecord == nil {
+		return nil, fmt.Errorf("no KYC record found for address %s", solanaAddress)
+	}
+	if publicRecord.Erased {
+		return nil, fmt.Errorf("KYC data of address %s has been erased", solanaAddress)
+	}
 
 	// Get the private data if available
-	userId, ok := publicData["userId"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid userId in public data")
-	}
+	kycRecord, err := getPrivateKYCRecord(ctx, publicRecord.UserID)
+	if err != nil || kycRecord == nil {
+		// If private data is unavailable or cannot be decrypted, just return the public data
+		kycRecord := publicRecord.kycRecord()
 
-	privateDataBytes, err := ctx.GetStub().GetPrivateData("kycPrivateData", userId)
-	if err != nil {
-		// If private data fails, just return the public data
-		return &KYCRecord{
-			UserID:        userId,
-			SolanaAddress: solanaAddress,
-			KYCVerified:   publicData["kycVerified"].(bool),
-			RiskScore:     int(publicData["riskScore"].(float64)),
-			CountryCode:   publicData["countryCode"].(string),
-		}, nil
+		err = applyPublicExpiry(ctx, kycRecord, publicRecord)
+		if err != nil {
+			return nil, err
+		}
+
+		return kycRecord, nil
 	}
 
-	var kycRecord KYCRecord
-	err = json.Unmarshal(privateDataBytes, &kycRecord)
+	kycRecord.Tier = effectiveTier(kycRecord)
+
+	err = applyExpiry(ctx, kycRecord)
 	if err != nil {
 		return nil, err
 	}
 
-	return &kycRecord, nil
+	return kycRecord, nil
 }
 
-// UpdateKYCStatus updates the KYC verification status for a user
+// UpdateKYCStatus updates the KYC verification status for a user. Only admins may call it,
+// since verifying a user raises their tier and restarts their review period.
 func (s *SmartContract) UpdateKYCStatus(ctx contractapi.TransactionContextInterface,
 	userId string,
 	solanaAddress string,
 	kycVerified bool,
 	reason string) error {
 
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Get current KYC data
-	kycBytes, err := ctx.GetStub().GetState(solanaAddress)
+	solanaAddress, err = resolveKYCAddress(ctx, solanaAddress)
 	if err != nil {
-		return fmt.Errorf("failed to read KYC status: %v", err)
+		return err
+	}
+	publicRecord, err := readPublicKYCRecord(ctx, solanaAddress)
+	if err != nil {
+		return err
 	}
-	if kycBytes == nil {
+	if publicRecord == nil {
 		return fmt.Errorf("no KYC record found for address %s", solanaAddress)
 	}
+	if publicRecord.Erased {
+		return fmt.Errorf("KYC data of address %s has been erased", solanaAddress)
+	}
+	if publicRecord.UserID != userId {
+		return fmt.Errorf("address %s does not belong to user %s", solanaAddress, userId)
+	}
 
-	var publicData map[string]interface{}
-	err = json.Unmarshal(kycBytes, &publicData)
-	if err != nil {
-		return err
+	// Update public data. Revoking verification also withdraws the tier, while restoring
+	// it grants at least the ID verified tier.
+	publicRecord.KYCVerified = kycVerified
+	if !kycVerified {
+		publicRecord.Tier = TierNone
+	} else if publicRecord.Tier != "" && !isFullyVerified(publicRecord.Tier) {
+		publicRecord.Tier = TierIDVerified
 	}
 
-	// Update public data
-	publicData["kycVerified"] = kycVerified
-	updatedPublicJSON, err := json.Marshal(publicData)
+	// Try to update private data if available
+	kycRecord, err := getPrivateKYCRecord(ctx, userId)
 	if err != nil {
 		return err
 	}
+	if kycRecord != nil {
+		kycRecord.KYCVerified = kycVerified
+		if !kycVerified {
+			kycRecord.Tier = TierNone
+		} else if !isFullyVerified(effectiveTier(kycRecord)) {
+			kycRecord.Tier = TierIDVerified
+		}
+		publicRecord.Tier = effectiveTier(kycRecord)
+		now, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		kycRecord.VerificationDate = now.Format(time.RFC3339)
+
+		updatedPrivateJSON, err := encodeKYCRecord(ctx, kycRecord)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutPrivateData("kycPrivateData", userId, updatedPrivateJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put KYC data: %v", err)
+		}
+		err = putKYCRecordDigest(ctx, kycRecord)
+		if err != nil {
+			return err
+		}
+
+		// A new verification restarts the expiry period
+		err = setReviewSchedule(ctx, publicRecord, kycRecord)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Update the state
-	err = ctx.GetStub().PutState(solanaAddress, updatedPublicJSON)
+	err = putPublicKYCRecord(ctx, publicRecord)
 	if err != nil {
 		return err
 	}
 
-	// Try to update private data if available
-	privateDataBytes, err := ctx.GetStub().GetPrivateData("kycPrivateData", userId)
-	if err == nil && privateDataBytes != nil {
-		var kycRecord KYCRecord
-		err = json.Unmarshal(privateDataBytes, &kycRecord)
-		if err == nil {
-			kycRecord.KYCVerified = kycVerified
-			kycRecord.VerificationDate = time.Now().Format(time.RFC3339)
-			
-			updatedPrivateJSON, err := json.Marshal(kycRecord)
-			if err == nil {
-				_ = ctx.GetStub().PutPrivateData("kycPrivateData", userId, updatedPrivateJSON)
-			}
+	// Records stored before the country index existed are indexed on their next update
+	if publicRecord.CountryCode != "" {
+		err = putCountryIndexEntry(ctx, publicRecord.CountryCode, solanaAddress)
+		if err != nil {
+			return err
 		}
 	}
 
-	// Record compliance event
-	_ = s.RecordComplianceEvent(ctx, userId, "KYC Status Update", reason)
+	err = emitKYCEvent(ctx, EventKYCStatusChanged, &KYCRecord{
+		UserID:          userId,
+		SolanaAddress:   solanaAddress,
+		KYCVerified:     kycVerified,
+		Tier:            publicRecord.Tier,
+		ScreeningStatus: publicRecord.ScreeningStatus,
+	})
+	if err != nil {
+		return err
+	}
 
-	return nil
+	// Record compliance event
+	return s.RecordComplianceEvent(ctx, userId, "KYC Status Update", reason)
 }
 
-// RecordComplianceEvent records a compliance event in the private data collection
+// RecordComplianceEvent records a compliance event in the private data collection.
+// Events are appended under the complianceEvent~userId~timestamp~txId~sequence
+// composite key, built from the transaction timestamp and ID so that every
+// endorsing peer produces the same write set and no event overwrites another.
 func (s *SmartContract) RecordComplianceEvent(ctx contractapi.TransactionContextInterface,
 	userId string,
 	action string,
 	description string) error {
 
+	kycCtx, ok := ctx.(*TransactionContext)
+	if !ok {
+		return fmt.Errorf("compliance events require the KYC transaction context")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
 	complianceRecord := ComplianceRecord{
 		UserID:      userId,
 		Action:      action,
 		Description: description,
-		Timestamp:   time.Now().Format(time.RFC3339),
+		Timestamp:   now.Format(time.RFC3339Nano),
+		TxID:        ctx.GetStub().GetTxID(),
+		Sequence:    kycCtx.nextComplianceSequence(),
 	}
 
-	complianceJSON, err := json.Marshal(complianceRecord)
+	complianceKey, err := complianceEventKey(ctx, complianceEventObjectType, complianceRecord.UserID, &complianceRecord, now)
 	if err != nil {
 		return err
 	}
 
-	// Create a unique key for the compliance record
-	complianceKey := fmt.Sprintf("%s_%s_%s", userId, action, time.Now().Format(time.RFC3339))
+	// The log is append-only, an existing entry is never replaced
+	existing, err := ctx.GetStub().GetPrivateData("complianceRecords", complianceKey)
+	if err != nil {
+		return fmt.Errorf("failed to read compliance record: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("compliance record %s already exists", complianceKey)
+	}
+
+	complianceJSON, err := json.Marshal(complianceRecord)
+	if err != nil {
+		return err
+	}
 
 	// Store in compliance collection
-	return ctx.GetStub().PutPrivateData("complianceRecords", complianceKey, complianceJSON)
+	err = ctx.GetStub().PutPrivateData("complianceRecords", complianceKey, complianceJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put compliance record: %v", err)
+	}
+
+	// Index the event by action, pointing back at the event key
+	actionKey, err := complianceEventKey(ctx, complianceActionObjectType, complianceRecord.Action, &complianceRecord, now)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData("complianceRecords", actionKey, []byte(complianceKey))
+	if err != nil {
+		return fmt.Errorf("failed to put compliance action index: %v", err)
+	}
+
+	return emitEvent(ctx, EventComplianceRecorded, &ComplianceEventPayload{
+		UserID:   complianceRecord.UserID,
+		Action:   complianceRecord.Action,
+		Sequence: complianceRecord.Sequence,
+	})
 }
 
-// ValidateTransaction validates if a transaction is allowed based on KYC status and risk score
+// ValidateTransaction validates if a transaction is allowed based on KYC status and the active compliance rules
 func (s *SmartContract) ValidateTransaction(ctx contractapi.TransactionContextInterface,
 	solanaAddress string,
 	transactionDataJSON string) (*ValidationResult, error) {
@@ -252,29 +609,152 @@ func (s *SmartContract) ValidateTransaction(ctx contractapi.TransactionContextIn
 	if err != nil {
 		return nil, err
 	}
+	if !isPositiveAmount(transactionData.Amount) {
+		return nil, fmt.Errorf("amount must be a positive number")
+	}
+	if transactionData.DestinationCountry != "" && !countryCodePattern.MatchString(transactionData.DestinationCountry) {
+		return nil, fmt.Errorf("destinationCountry must be an ISO 3166-1 alpha-2 code")
+	}
+	if transactionData.WalletBalance != nil && *transactionData.WalletBalance < 0 {
+		return nil, fmt.Errorf("walletBalance must not be negative")
+	}
+
+	result, err := s.validateTransaction(ctx, solanaAddress, &transactionData)
+	if err != nil || result.IsValid {
+		return result, err
+	}
+
+	err = emitEvent(ctx, EventTransactionRejected, &TransactionEventPayload{
+		TransactionID: transactionData.TransactionID,
+		FromAddress:   solanaAddress,
+		Amount:        transactionData.Amount,
+		Currency:      transactionData.Currency,
+		Status:        result.Status,
+		RuleID:        result.RuleID,
+		Reason:        result.Message,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// isPositiveAmount reports whether amount is a finite number above zero
+func isPositiveAmount(amount float64) bool {
+	return amount > 0 && !math.IsNaN(amount) && !math.IsInf(amount, 0)
+}
+
+// validateTransaction checks a transaction against the sender's KYC record and the compliance rules
+func (s *SmartContract) validateTransaction(ctx contractapi.TransactionContextInterface,
+	solanaAddress string,
+	transactionData *TransactionValidation) (*ValidationResult, error) {
 
 	// Get KYC status
 	kycRecord, err := s.GetKYCStatus(ctx, solanaAddress)
 	if err != nil {
+		message := "KYC record not found"
+		if revoked, _ := isAddressRevoked(ctx, solanaAddress); revoked {
+			message = "Address revoked"
+		}
 		return &ValidationResult{
 			IsValid: false,
-			Message: "KYC record not found",
+			Message: message,
 		}, nil
 	}
 
-	// Validate based on KYC status and risk score
-	if !kycRecord.KYCVerified {
+	// Validate based on KYC tier. Tiers below ID verification may transact within reduced limits.
+	tier := effectiveTier(kycRecord)
+	if tier == TierNone {
 		return &ValidationResult{
 			IsValid: false,
 			Message: "KYC not verified",
 		}, nil
 	}
 
-	// Check risk score
-	if kycRecord.RiskScore > 70 && transactionData.Amount > 1000 {
+	// Expired verifications must be renewed before transacting
+	if kycRecord.ExpiryStatus == ExpiryExpired {
+		return &ValidationResult{
+			IsValid: false,
+			Message: fmt.Sprintf("KYC verification expired on %s", kycRecord.ExpiryDate),
+			Status:  ExpiryExpired,
+		}, nil
+	}
+
+	// Records held or blocked by sanctions screening may not transact
+	if kycRecord.ScreeningStatus == ScreeningHeld || kycRecord.ScreeningStatus == ScreeningBlocked {
+		return &ValidationResult{
+			IsValid: false,
+			Message: "KYC record is held by sanctions screening",
+			Status:  kycRecord.ScreeningStatus,
+		}, nil
+	}
+
+	// Screen the beneficiary against the watchlist
+	if transactionData.BeneficiaryName != "" {
+		matches, err := screenName(ctx, transactionData.BeneficiaryName, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			description := fmt.Sprintf("Transaction %s held on beneficiary watchlist match: %s",
+				transactionData.TransactionID, describeMatches(matches))
+			err = s.RecordComplianceEvent(ctx, kycRecord.UserID, "Sanctions Screening Match", description)
+			if err != nil {
+				return nil, err
+			}
+
+			return &ValidationResult{
+				IsValid: false,
+				Message: "Beneficiary matches a sanctions or watchlist entry",
+				Status:  ScreeningHeld,
+			}, nil
+		}
+	}
+
+	// Check the limits of the user's tier
+	tierLimitID, err := checkTierLimits(ctx, tier, transactionData)
+	if err != nil {
+		return nil, err
+	}
+	if tierLimitID == "tier-balance-required" {
+		return &ValidationResult{
+			IsValid: false,
+			Message: fmt.Sprintf("Transaction data must include walletBalance for the balance limit of tier %s", tier),
+			RuleID:  tierLimitID,
+		}, nil
+	}
+	if tierLimitID != "" {
+		return &ValidationResult{
+			IsValid: false,
+			Message: fmt.Sprintf("Transaction exceeds the %s limit of tier %s", tierLimitID, tier),
+			RuleID:  tierLimitID,
+		}, nil
+	}
+
+	// Evaluate the active compliance rules
+	ruleSet, err := getActiveRuleSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rule := ruleSet.evaluate(kycRecord.RiskScore, transactionData); rule != nil {
 		return &ValidationResult{
 			IsValid: false,
-			Message: "Transaction amount exceeds limit for high-risk user",
+			Message: fmt.Sprintf("Transaction rejected by rule %s of rule set %d", rule.ID, ruleSet.Version),
+			RuleID:  rule.ID,
+		}, nil
+	}
+
+	// Check cumulative spend over the rolling windows
+	limit, window, err := checkVelocityLimits(ctx, solanaAddress, kycRecord.RiskScore, transactionData)
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil {
+		return &ValidationResult{
+			IsValid: false,
+			Message: fmt.Sprintf("Transaction exceeds %s velocity limit %s", window, limit.ID),
+			RuleID:  limit.ID,
 		}, nil
 	}
 
@@ -285,79 +765,96 @@ func (s *SmartContract) ValidateTransaction(ctx contractapi.TransactionContextIn
 		transactionData.Currency,
 		transactionData.Destination)
 	
-	_ = s.RecordComplianceEvent(ctx, kycRecord.UserID, "Transaction Validation", description)
+	err = s.RecordComplianceEvent(ctx, kycRecord.UserID, "Transaction Validation", description)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tell the caller whether RecordTransaction will require an acknowledged Travel Rule message
+	travelRuleThreshold, err := getTravelRuleThreshold(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	return &ValidationResult{
-		IsValid: true,
-		Message: "Transaction validated successfully",
+		IsValid:            true,
+		Message:            "Transaction validated successfully",
+		Status:             kycRecord.ExpiryStatus,
+		TravelRuleRequired: transactionData.Amount >= travelRuleThreshold,
 	}, nil
 }
 
-// QueryKYCByCountry retrieves all KYC records for a specific country
+// QueryKYCByCountry retrieves a page of the KYC records of a country through the country
+// index. kycVerified is "true" or "false" to filter by verification status, or empty for
+// both; records are further limited to risk scores within [minRiskScore, maxRiskScore].
+// Filtered out records count towards the page size, so a page may hold fewer records.
 func (s *SmartContract) QueryKYCByCountry(ctx contractapi.TransactionContextInterface,
-	countryCode string) ([]*KYCRecord, error) {
+	countryCode string,
+	kycVerified string,
+	minRiskScore int,
+	maxRiskScore int,
+	pageSize int32,
+	bookmark string) (*KYCQueryResult, error) {
+
+	if kycVerified != "" && kycVerified != "true" && kycVerified != "false" {
+		return nil, fmt.Errorf("kycVerified must be true, false or empty")
+	}
+	if minRiskScore > maxRiskScore {
+		return nil, fmt.Errorf("minRiskScore must not be greater than maxRiskScore")
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
 
-	// For LevelDB, we need to use GetStateByRange instead of GetQueryResult
-	// We'll iterate through all KYC records and filter by country code
-	
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(countryIndexObjectType, []string{countryCode}, pageSize, bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var records []*KYCRecord
+	result := &KYCQueryResult{
+		Records: []*KYCRecord{},
+	}
 	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
+		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		var publicData map[string]interface{}
-		err = json.Unmarshal(queryResult.Value, &publicData)
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
 		if err != nil {
-			continue
-		}
-
-		// Check if this record matches the requested country code
-		recordCountryCode, ok := publicData["countryCode"].(string)
-		if !ok || recordCountryCode != countryCode {
-			continue
+			return nil, err
 		}
+		solanaAddress := keyParts[1]
 
-		userId, ok := publicData["userId"].(string)
-		if !ok {
-			continue
+		publicRecord, err := readPublicKYCRecord(ctx, solanaAddress)
+		if err != nil {
+			return nil, err
 		}
-
-		solanaAddress, ok := publicData["solanaAddress"].(string)
-		if !ok {
+		if publicRecord == nil {
 			continue
 		}
-
-		kycVerified, ok := publicData["kycVerified"].(bool)
-		if !ok {
+		if kycVerified != "" && kycVerified != strconv.FormatBool(publicRecord.KYCVerified) {
 			continue
 		}
-
-		riskScore, ok := publicData["riskScore"].(float64)
-		if !ok {
+		if publicRecord.RiskScore < minRiskScore || publicRecord.RiskScore > maxRiskScore {
 			continue
 		}
 
-		records = append(records, &KYCRecord{
-			UserID:        userId,
-			SolanaAddress: solanaAddress,
-			KYCVerified:   kycVerified,
-			RiskScore:     int(riskScore),
-			CountryCode:   countryCode,
-		})
+		kycRecord := publicRecord.kycRecord()
+		result.Records = append(result.Records, kycRecord)
 	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+	result.Bookmark = responseMetadata.Bookmark
 
-	return records, nil
+	return result, nil
 }
 
-// RecordTransaction records a transaction in the ledger
+// RecordTransaction records a transaction in the ledger. Recording is idempotent: a
+// transaction that is already recorded with the same content succeeds without writing,
+// while one recorded with different content fails with a TransactionConflictError.
+// Only the bridge may record transactions, as each one counts towards the sender's
+// velocity limits.
 func (s *SmartContract) RecordTransaction(ctx contractapi.TransactionContextInterface,
 	transactionID string,
 	fromAddress string,
@@ -368,68 +865,101 @@ func (s *SmartContract) RecordTransaction(ctx contractapi.TransactionContextInte
 	memo string,
 	timestamp string) error {
 
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	parsedAmount, err := strconv.ParseFloat(amount, 64)
+	if err != nil || !isPositiveAmount(parsedAmount) {
+		return fmt.Errorf("amount must be a positive number")
+	}
+
 	// Create transaction record
 	transactionRecord := TransactionRecord{
+		DocType:             docTypeTransaction,
 		TransactionID:       transactionID,
 		FromAddress:         fromAddress,
 		ToAddress:           toAddress,
 		Amount:              amount,
+		AmountValue:         parsedAmount,
 		SourceCurrency:      sourceCurrency,
 		DestinationCurrency: destinationCurrency,
 		Memo:                memo,
 		Timestamp:           timestamp,
-		Status:              "COMPLETED",
+		Status:              TransactionInitiated,
+	}
+	transactionRecord.ContentHash, err = transactionContentHash(&transactionRecord)
+	if err != nil {
+		return err
 	}
 
-	// Convert to JSON
-	transactionJSON, err := json.Marshal(transactionRecord)
+	// A retry of a recorded transaction leaves it, and the sender's spend, unchanged
+	existing, err := readTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	duplicate, err := checkDuplicateTransaction(existing, &transactionRecord)
+	if err != nil || duplicate {
+		return err
+	}
+
+	// Transfers above the Travel Rule threshold need the beneficiary institution's acknowledgement
+	err = requireTravelRuleAcknowledgement(ctx, &transactionRecord, parsedAmount)
 	if err != nil {
 		return err
 	}
 
+	// Record the time the transaction is listed under in address histories, so that
+	// reindexing replaces its history entries instead of adding new ones
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	transactionRecord.HistoryTimestamp = txHistoryTimestamp(timestamp, now)
+	transactionRecord.SpentAt = now.Format(keyTimeLayout)
+
 	// Store in the ledger
-	err = ctx.GetStub().PutState("tx_"+transactionID, transactionJSON)
+	err = putTransaction(ctx, &transactionRecord)
 	if err != nil {
-		return fmt.Errorf("failed to record transaction: %v", err)
+		return err
 	}
 
-	// Also index by sender and recipient for faster queries
-	// Index for sender
-	fromAddressIndex := "from~tx~" + fromAddress + "~" + transactionID
-	err = ctx.GetStub().PutState(fromAddressIndex, []byte(transactionID))
+	// Also index by sender and recipient, and by date for address histories
+	err = putTxIndexEntries(ctx, &transactionRecord)
 	if err != nil {
-		return fmt.Errorf("failed to create from address index: %v", err)
+		return err
 	}
 
-	// Index for recipient
-	toAddressIndex := "to~tx~" + toAddress + "~" + transactionID
-	err = ctx.GetStub().PutState(toAddressIndex, []byte(transactionID))
+	// Count the amount towards the sender's velocity limits
+	err = addSpendDelta(ctx, fromAddress, sourceCurrency, parsedAmount, now)
 	if err != nil {
-		return fmt.Errorf("failed to create to address index: %v", err)
+		return err
 	}
 
-	return nil
+	return emitEvent(ctx, EventTransactionRecorded, &TransactionEventPayload{
+		TransactionID: transactionID,
+		FromAddress:   fromAddress,
+		ToAddress:     toAddress,
+		Amount:        parsedAmount,
+		Currency:      sourceCurrency,
+		Status:        transactionRecord.Status,
+	})
 }
 
 // GetTransaction gets a transaction by ID
 func (s *SmartContract) GetTransaction(ctx contractapi.TransactionContextInterface,
 	transactionID string) (*TransactionRecord, error) {
 	
-	transactionJSON, err := ctx.GetStub().GetState("tx_" + transactionID)
+	transaction, err := readTransaction(ctx, transactionID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read transaction: %v", err)
+		return nil, err
 	}
-	if transactionJSON == nil {
+	if transaction == nil {
 		return nil, fmt.Errorf("transaction %s does not exist", transactionID)
 	}
 
-	var transaction TransactionRecord
-	err = json.Unmarshal(transactionJSON, &transaction)
-	if err != nil {
-		return nil, err
-	}
-
-	return &transaction, nil
+	return transaction, nil
 }
 
 // GetTransactionsByAddress gets all transactions for an address (either sender or recipient)
@@ -437,14 +967,14 @@ func (s *SmartContract) GetTransactionsByAddress(ctx contractapi.TransactionCont
 	address string) ([]*TransactionRecord, error) {
 	
 	// Get sender transactions
-	fromResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("from~tx~", []string{address})
+	fromResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexObjectType, []string{txFromIndex, address})
 	if err != nil {
 		return nil, err
 	}
 	defer fromResultsIterator.Close()
 
 	// Get recipient transactions
-	toResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("to~tx~", []string{address})
+	toResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexObjectType, []string{txToIndex, address})
 	if err != nil {
 		return nil, err
 	}
@@ -495,7 +1025,10 @@ func (s *SmartContract) GetTransactionsByAddress(ctx contractapi.TransactionCont
 
 // Main function starts the chaincode
 func main() {
-	chaincode, err := contractapi.NewChaincode(&SmartContract{})
+	kycContract := new(SmartContract)
+	kycContract.TransactionContextHandler = new(TransactionContext)
+
+	chaincode, err := contractapi.NewChaincode(kycContract)
 	if err != nil {
 		fmt.Printf("Error creating KYC chaincode: %v\n", err)
 		return
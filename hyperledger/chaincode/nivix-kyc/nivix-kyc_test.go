@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestTransactionsRequirePositiveAmounts(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", attrs: map[string]string{"nivix.bridge": "true"}})
+	contract := new(SmartContract)
+
+	stub.MockTransactionStart("tx1")
+	for _, amount := range []string{"0", "-250", "NaN", "+Inf", "-Inf", "1e400"} {
+		err := contract.RecordTransaction(ctx, "tx123", "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+			"9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", amount, "USD", "EUR", "", "2025-06-01T12:00:00Z")
+		if err == nil {
+			t.Errorf("RecordTransaction accepted amount %s", amount)
+		}
+
+		_, err = contract.ValidateTransaction(ctx, "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+			`{"transactionId":"tx123","amount":`+amount+`,"currency":"USD"}`)
+		if err == nil {
+			t.Errorf("ValidateTransaction accepted amount %s", amount)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// walletNonceObjectType is the composite key object type of used wallet ownership challenge nonces
+const walletNonceObjectType = "walletNonce~address~nonce"
+
+// maxWalletChallengeLifetime bounds how far in the future a challenge may expire
+const maxWalletChallengeLifetime = 24 * time.Hour
+
+// base58Alphabet is the Bitcoin base58 alphabet used by Solana addresses and signatures
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// WalletOwnershipProof is a signature by a Solana wallet over the challenge message
+// returned by GetWalletChallenge. The signature is base58 encoded, as produced by
+// Solana wallets.
+type WalletOwnershipProof struct {
+	Nonce     string `json:"nonce"`
+	ExpiresAt string `json:"expiresAt"`
+	Signature string `json:"signature"`
+}
+
+// decodeBase58 decodes a base58 string
+func decodeBase58(value string) ([]byte, error) {
+	leadingZeros := 0
+	for leadingZeros < len(value) && value[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+
+	// Accumulate the number in little-endian bytes
+	decoded := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		digit := strings.IndexByte(base58Alphabet, value[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", value[i])
+		}
+
+		carry := digit
+		for j := range decoded {
+			carry += int(decoded[j]) * 58
+			decoded[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			decoded = append(decoded, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	result := make([]byte, leadingZeros+len(decoded))
+	for i, b := range decoded {
+		result[len(result)-1-i] = b
+	}
+
+	return result, nil
+}
+
+// decodeSolanaAddress decodes a Solana address into its ed25519 public key
+func decodeSolanaAddress(address string) (ed25519.PublicKey, error) {
+	publicKey, err := decodeBase58(address)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s is not a valid Solana address", address)
+	}
+
+	return ed25519.PublicKey(publicKey), nil
+}
+
+// walletChallengeMessage builds the message a wallet signs to prove it belongs to a user
+func walletChallengeMessage(userId string, address string, nonce string, expiresAt string) string {
+	return fmt.Sprintf("Nivix KYC wallet ownership\nuserId: %s\naddress: %s\nnonce: %s\nexpiresAt: %s",
+		userId, address, nonce, expiresAt)
+}
+
+// verifyWalletSignature checks that a proof's signature over its challenge was made by the
+// key of the address
+func verifyWalletSignature(userId string, address string, proof *WalletOwnershipProof) error {
+	publicKey, err := decodeSolanaAddress(address)
+	if err != nil {
+		return err
+	}
+
+	signature, err := decodeBase58(proof.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("wallet ownership signature must be a base58 encoded ed25519 signature")
+	}
+	message := walletChallengeMessage(userId, address, proof.Nonce, proof.ExpiresAt)
+	if !ed25519.Verify(publicKey, []byte(message), signature) {
+		return fmt.Errorf("wallet ownership signature does not match address %s", address)
+	}
+
+	return nil
+}
+
+// verifyWalletOwnership checks a wallet's signature over its challenge and consumes the
+// challenge nonce so that the proof cannot be replayed
+func verifyWalletOwnership(ctx contractapi.TransactionContextInterface, userId string, address string, proof *WalletOwnershipProof) error {
+	if proof == nil {
+		return fmt.Errorf("a wallet ownership proof is required for address %s", address)
+	}
+	if len(proof.Nonce) == 0 || len(proof.Nonce) > 128 {
+		return fmt.Errorf("wallet ownership nonce must be between 1 and 128 characters")
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, proof.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("wallet ownership expiresAt must be an RFC3339 timestamp")
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.Before(expiresAt) {
+		return fmt.Errorf("wallet ownership challenge has expired")
+	}
+	if expiresAt.Sub(now) > maxWalletChallengeLifetime {
+		return fmt.Errorf("wallet ownership challenge must expire within %s", maxWalletChallengeLifetime)
+	}
+
+	err = verifyWalletSignature(userId, address, proof)
+	if err != nil {
+		return err
+	}
+
+	nonceKey, err := ctx.GetStub().CreateCompositeKey(walletNonceObjectType, []string{address, proof.Nonce})
+	if err != nil {
+		return fmt.Errorf("failed to create wallet nonce key: %v", err)
+	}
+	used, err := ctx.GetStub().GetState(nonceKey)
+	if err != nil {
+		return fmt.Errorf("failed to read wallet nonce: %v", err)
+	}
+	if used != nil {
+		return fmt.Errorf("wallet ownership nonce %s has already been used", proof.Nonce)
+	}
+
+	return ctx.GetStub().PutState(nonceKey, []byte(ctx.GetStub().GetTxID()))
+}
+
+// requireWalletProof verifies the WalletOwnershipProof in the "wallet_proof" key of the
+// transient map unless the address is already linked to the user
+func requireWalletProof(ctx contractapi.TransactionContextInterface, userId string, address string) error {
+	owned, err := addressBelongsToUser(ctx, userId, address)
+	if err != nil {
+		return err
+	}
+	if owned {
+		return nil
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+	transientProofJSON, ok := transientMap["wallet_proof"]
+	if !ok {
+		return fmt.Errorf("wallet_proof not found in the transient map input")
+	}
+	proof, err := parseWalletOwnershipProof(transientProofJSON)
+	if err != nil {
+		return err
+	}
+
+	return verifyWalletOwnership(ctx, userId, address, proof)
+}
+
+// parseWalletOwnershipProof decodes a wallet ownership proof
+func parseWalletOwnershipProof(proofJSON []byte) (*WalletOwnershipProof, error) {
+	var proof WalletOwnershipProof
+	err := json.Unmarshal(proofJSON, &proof)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal wallet ownership proof JSON: %v", err)
+	}
+
+	return &proof, nil
+}
+
+// GetWalletChallenge returns the message a wallet must sign to prove that it belongs to a user.
+// The nonce is chosen by the client and can be used only once per address.
+func (s *SmartContract) GetWalletChallenge(ctx contractapi.TransactionContextInterface,
+	userId string,
+	solanaAddress string,
+	nonce string,
+	expiresAt string) (string, error) {
+
+	_, err := decodeSolanaAddress(solanaAddress)
+	if err != nil {
+		return "", err
+	}
+	if _, err := time.Parse(time.RFC3339, expiresAt); err != nil {
+		return "", fmt.Errorf("expiresAt must be an RFC3339 timestamp")
+	}
+
+	return walletChallengeMessage(userId, solanaAddress, nonce, expiresAt), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// rfc8032PublicKey is the public key of RFC 8032 section 7.1, test 1
+const rfc8032PublicKey = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+
+func TestDecodeBase58(t *testing.T) {
+	tests := []struct {
+		encoded string
+		decoded string
+	}{
+		{"", ""},
+		{"1", "00"},
+		{"11", "0000"},
+		{"2g", "61"},
+		{"a3gV", "626262"},
+		{"aPEr", "636363"},
+		{"2NEpo7TZRRrLZSi2U", hex.EncodeToString([]byte("Hello World!"))},
+		{"11233QC4", "0000287fb4cd"},
+		{"FVen3X669xLzsi6N2V91DoiyzHzg1uAgqiT8jZ9nS96Z", rfc8032PublicKey},
+	}
+
+	for _, test := range tests {
+		decoded, err := decodeBase58(test.encoded)
+		if err != nil {
+			t.Fatalf("decodeBase58(%q) failed: %v", test.encoded, err)
+		}
+		if hex.EncodeToString(decoded) != test.decoded {
+			t.Errorf("decodeBase58(%q) = %x, want %s", test.encoded, decoded, test.decoded)
+		}
+	}
+}
+
+func TestDecodeBase58RejectsInvalidCharacters(t *testing.T) {
+	for _, encoded := range []string{"0", "O", "I", "l", "abc+"} {
+		_, err := decodeBase58(encoded)
+		if err == nil {
+			t.Errorf("decodeBase58(%q) succeeded, want an error", encoded)
+		}
+	}
+}
+
+func TestDecodeSolanaAddress(t *testing.T) {
+	publicKey, err := decodeSolanaAddress("FVen3X669xLzsi6N2V91DoiyzHzg1uAgqiT8jZ9nS96Z")
+	if err != nil {
+		t.Fatalf("decodeSolanaAddress failed: %v", err)
+	}
+
+	// RFC 8032 section 7.1, test 1: the signature of the empty message
+	signature, err := decodeBase58("5awYiUvGiDFA33EJjj4TXJG44a5afJc8QjWRpGgQiu6b23jCr7yndW2fmp9ujwqJVe32J456wV3VF78Asb1obnTc")
+	if err != nil {
+		t.Fatalf("decodeBase58 failed: %v", err)
+	}
+	expected, _ := hex.DecodeString("e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155" +
+		"5fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+	if !bytes.Equal(signature, expected) {
+		t.Fatalf("decoded signature = %x, want %x", signature, expected)
+	}
+	if !ed25519.Verify(publicKey, nil, signature) {
+		t.Fatal("RFC 8032 signature does not verify against the decoded address")
+	}
+
+	for _, address := range []string{"", "2g", "FVen3X669xLzsi6N2V91DoiyzHzg1uAgqiT8jZ9nS96Z1"} {
+		_, err := decodeSolanaAddress(address)
+		if err == nil {
+			t.Errorf("decodeSolanaAddress(%q) succeeded, want an error", address)
+		}
+	}
+}
+
+func TestVerifyWalletSignature(t *testing.T) {
+	// Signed with the RFC 8032 section 7.1, test 1 private key
+	proof := &WalletOwnershipProof{
+		Nonce:     "b7f3c1d2",
+		ExpiresAt: "2025-05-17T13:00:00Z",
+		Signature: "5GgPm5iuiSQmqQbcwMxFxwfotP6JYzqdcdJur5soD38hmYwUG9h8mYUnLgDd1MMuynASExuuGCv8VCuRxy15G8ue",
+	}
+	address := "FVen3X669xLzsi6N2V91DoiyzHzg1uAgqiT8jZ9nS96Z"
+
+	err := verifyWalletSignature("user123", address, proof)
+	if err != nil {
+		t.Fatalf("verifyWalletSignature failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userId  string
+		address string
+		proof   WalletOwnershipProof
+	}{
+		{"other user", "user456", address, *proof},
+		{"other address", "user123", "5yNmX8RqFbKpWzT3vDcJhQa2LgE7sUoP9iBk4nMwVtAe", *proof},
+		{"other nonce", "user123", address, WalletOwnershipProof{Nonce: "b7f3c1d3", ExpiresAt: proof.ExpiresAt, Signature: proof.Signature}},
+		{"other expiry", "user123", address, WalletOwnershipProof{Nonce: proof.Nonce, ExpiresAt: "2025-05-18T13:00:00Z", Signature: proof.Signature}},
+		{"short signature", "user123", address, WalletOwnershipProof{Nonce: proof.Nonce, ExpiresAt: proof.ExpiresAt, Signature: "2g"}},
+		{"invalid signature", "user123", address, WalletOwnershipProof{Nonce: proof.Nonce, ExpiresAt: proof.ExpiresAt, Signature: "0OIl"}},
+	}
+
+	for _, test := range tests {
+		err := verifyWalletSignature(test.userId, test.address, &test.proof)
+		if err == nil {
+			t.Errorf("%s: verifyWalletSignature succeeded, want an error", test.name)
+		}
+	}
+}
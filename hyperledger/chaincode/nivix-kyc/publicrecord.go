@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// publicKYCSchemaVersion is the schema version of the public KYC references written by this
+// chaincode. References stored before the schema was versioned have no schemaVersion and
+// are read as version 1.
+//
+// Version 2 adds schemaVersion and docType, and stores every field with its JSON type.
+const publicKYCSchemaVersion = 2
+
+// PublicKYCRecord is the public reference of a KYC record, stored in the world state under
+// the user's primary address. It holds no personal data.
+type PublicKYCRecord struct {
+	SchemaVersion   int    `json:"schemaVersion"`
+	DocType         string `json:"docType"`
+	UserID          string `json:"userId"`
+	SolanaAddress   string `json:"solanaAddress"`
+	KYCVerified     bool   `json:"kycVerified"`
+	RiskScore       int    `json:"riskScore"`
+	CountryCode     string `json:"countryCode,omitempty"`
+	Tier            string `json:"tier,omitempty"`
+	ScreeningStatus string `json:"screeningStatus,omitempty"`
+	ExpiryDate      string `json:"expiryDate,omitempty"`
+	ReviewDate      string `json:"reviewDate,omitempty"`
+	Erased          bool   `json:"erased,omitempty"`
+	ErasedAt        string `json:"erasedAt,omitempty"`
+}
+
+// MigrationResult reports a batch of a public KYC record migration
+type MigrationResult struct {
+	FromVersion     int      `json:"fromVersion"`
+	ToVersion       int      `json:"toVersion"`
+	Scanned         int      `json:"scanned"`
+	Migrated        int      `json:"migrated"`
+	Failed          int      `json:"failed"`
+	FailedAddresses []string `json:"failedAddresses,omitempty"`
+	Bookmark        string   `json:"bookmark"`
+}
+
+// publicStringField reads an optional string field of an untyped public KYC reference
+func publicStringField(fields map[string]interface{}, name string) (string, error) {
+	switch value := fields[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid %s in public data", name)
+}
+
+// publicBoolField reads an optional boolean field of an untyped public KYC reference,
+// accepting booleans stored as strings
+func publicBoolField(fields map[string]interface{}, name string) (bool, error) {
+	switch value := fields[name].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return value, nil
+	case string:
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return false, fmt.Errorf("invalid %s in public data", name)
+}
+
+// publicIntField reads an optional integer field of an untyped public KYC reference,
+// accepting integers stored as strings
+func publicIntField(fields map[string]interface{}, name string) (int, error) {
+	switch value := fields[name].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if value == math.Trunc(value) {
+			return int(value), nil
+		}
+	case string:
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid %s in public data", name)
+}
+
+// decodePublicKYCRecord parses a public KYC reference of any schema version up to the
+// current one. Fields missing from older references are left empty.
+func decodePublicKYCRecord(publicJSON []byte) (*PublicKYCRecord, error) {
+	var fields map[string]interface{}
+	err := json.Unmarshal(publicJSON, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &PublicKYCRecord{}
+	if record.SchemaVersion, err = publicIntField(fields, "schemaVersion"); err != nil {
+		return nil, err
+	}
+	if record.DocType, err = publicStringField(fields, "docType"); err != nil {
+		return nil, err
+	}
+	if record.UserID, err = publicStringField(fields, "userId"); err != nil {
+		return nil, err
+	}
+	if record.SolanaAddress, err = publicStringField(fields, "solanaAddress"); err != nil {
+		return nil, err
+	}
+	if record.KYCVerified, err = publicBoolField(fields, "kycVerified"); err != nil {
+		return nil, err
+	}
+	if record.RiskScore, err = publicIntField(fields, "riskScore"); err != nil {
+		return nil, err
+	}
+	if record.CountryCode, err = publicStringField(fields, "countryCode"); err != nil {
+		return nil, err
+	}
+	if record.Tier, err = publicStringField(fields, "tier"); err != nil {
+		return nil, err
+	}
+	if record.ScreeningStatus, err = publicStringField(fields, "screeningStatus"); err != nil {
+		return nil, err
+	}
+	if record.ExpiryDate, err = publicStringField(fields, "expiryDate"); err != nil {
+		return nil, err
+	}
+	if record.ReviewDate, err = publicStringField(fields, "reviewDate"); err != nil {
+		return nil, err
+	}
+	if record.Erased, err = publicBoolField(fields, "erased"); err != nil {
+		return nil, err
+	}
+	if record.ErasedAt, err = publicStringField(fields, "erasedAt"); err != nil {
+		return nil, err
+	}
+
+	if record.UserID == "" {
+		return nil, fmt.Errorf("invalid userId in public data")
+	}
+	if record.SolanaAddress == "" {
+		return nil, fmt.Errorf("invalid solanaAddress in public data")
+	}
+	if record.SchemaVersion == 0 {
+		record.SchemaVersion = 1
+	}
+	if record.SchemaVersion > publicKYCSchemaVersion {
+		return nil, fmt.Errorf("public KYC record has schema version %d, this chaincode supports up to %d",
+			record.SchemaVersion, publicKYCSchemaVersion)
+	}
+
+	return record, nil
+}
+
+// readPublicKYCRecord reads the public KYC reference stored under an address, or returns
+// nil if there is none
+func readPublicKYCRecord(ctx contractapi.TransactionContextInterface, address string) (*PublicKYCRecord, error) {
+	key, err := kycKey(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	publicJSON, err := getStateWithLegacyKey(ctx, key, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC status: %v", err)
+	}
+	if publicJSON == nil {
+		return nil, nil
+	}
+
+	return decodePublicKYCRecord(publicJSON)
+}
+
+// putPublicKYCRecord writes a public KYC reference under its address with the current
+// schema version
+func putPublicKYCRecord(ctx contractapi.TransactionContextInterface, record *PublicKYCRecord) error {
+	record.SchemaVersion = publicKYCSchemaVersion
+	record.DocType = docTypeKYC
+
+	publicJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	key, err := kycKey(ctx, record.SolanaAddress)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, publicJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put KYC status: %v", err)
+	}
+
+	// A record still stored under its bare address moves to the namespaced key
+	return deleteLegacyKey(ctx, record.SolanaAddress)
+}
+
+// getPublicKYCRecords returns every public KYC reference, including references still
+// stored under simple keys until MigrateKeyspace has moved them
+func getPublicKYCRecords(ctx contractapi.TransactionContextInterface) ([]*PublicKYCRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(kycObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []*PublicKYCRecord{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := decodePublicKYCRecord(queryResult.Value)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	legacyIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer legacyIterator.Close()
+
+	for legacyIterator.HasNext() {
+		queryResult, err := legacyIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// Transactions and indexes also used simple keys
+		record, err := decodePublicKYCRecord(queryResult.Value)
+		if err != nil || record.SolanaAddress != queryResult.Key {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// kycRecord builds the KYC record reported from a public reference when the private
+// record cannot be read
+func (record *PublicKYCRecord) kycRecord() *KYCRecord {
+	kycRecord := &KYCRecord{
+		UserID:          record.UserID,
+		SolanaAddress:   record.SolanaAddress,
+		KYCVerified:     record.KYCVerified,
+		RiskScore:       record.RiskScore,
+		CountryCode:     record.CountryCode,
+		ScreeningStatus: record.ScreeningStatus,
+		Tier:            record.Tier,
+	}
+	kycRecord.Tier = effectiveTier(kycRecord)
+
+	return kycRecord
+}
+
+// MigrateRecords upgrades the public KYC references stored with schema version fromVersion
+// to the current version. Migrated references are rewritten with their document type, so
+// that rich queries find them, and are added to the country index. Each call scans up to
+// pageSize public KYC references and returns a bookmark to pass to the next call until it
+// is empty. References that cannot be decoded are left unchanged and reported by address.
+// References still stored under simple keys are only found once MigrateKeyspace has moved
+// them.
+func (s *SmartContract) MigrateRecords(ctx contractapi.TransactionContextInterface,
+	fromVersion int,
+	pageSize int,
+	bookmark string) (*MigrationResult, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fromVersion < 1 || fromVersion >= publicKYCSchemaVersion {
+		return nil, fmt.Errorf("fromVersion must be between 1 and %d", publicKYCSchemaVersion-1)
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	startKey := ""
+	if bookmark != "" {
+		startKeyBytes, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark: %v", err)
+		}
+		startKey = string(startKeyBytes)
+	}
+
+	entries, nextKey, err := scanObjectType(ctx, kycObjectType, startKey, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{
+		FromVersion: fromVersion,
+		ToVersion:   publicKYCSchemaVersion,
+		Scanned:     len(entries),
+	}
+	if nextKey != "" {
+		result.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(nextKey))
+	}
+	for _, entry := range entries {
+		record, err := decodePublicKYCRecord(entry.Value)
+		if err != nil {
+			// Report records that cannot be decoded so that they can be repaired by hand
+			address := entry.Key
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+			if err == nil && len(attributes) > 0 {
+				address = attributes[0]
+			}
+			result.Failed++
+			result.FailedAddresses = append(result.FailedAddresses, address)
+			continue
+		}
+		if record.SchemaVersion != fromVersion {
+			continue
+		}
+
+		err = putPublicKYCRecord(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+		if record.CountryCode != "" && !record.Erased {
+			err = putCountryIndexEntry(ctx, record.CountryCode, record.SolanaAddress)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result.Migrated++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestDecodePublicKYCRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		json   string
+		record *PublicKYCRecord
+	}{
+		{"version 1 with string fields",
+			`{"userId":"user123","solanaAddress":"A1","kycVerified":"true","riskScore":"30","countryCode":"US"}`,
+			&PublicKYCRecord{SchemaVersion: 1, UserID: "user123", SolanaAddress: "A1", KYCVerified: true, RiskScore: 30, CountryCode: "US"}},
+		{"version 2",
+			`{"schemaVersion":2,"docType":"kyc","userId":"user123","solanaAddress":"A1","kycVerified":false,"riskScore":75,"tier":"ID_VERIFIED","expiryDate":"2026-06-01"}`,
+			&PublicKYCRecord{SchemaVersion: 2, DocType: docTypeKYC, UserID: "user123", SolanaAddress: "A1", RiskScore: 75, Tier: TierIDVerified, ExpiryDate: "2026-06-01"}},
+		{"erased tombstone",
+			`{"schemaVersion":2,"docType":"kyc","userId":"user123","solanaAddress":"A1","kycVerified":false,"riskScore":0,"erased":true,"erasedAt":"2025-06-01T00:00:00Z"}`,
+			&PublicKYCRecord{SchemaVersion: 2, DocType: docTypeKYC, UserID: "user123", SolanaAddress: "A1", Erased: true, ErasedAt: "2025-06-01T00:00:00Z"}},
+		{"fractional risk score", `{"userId":"user123","solanaAddress":"A1","riskScore":30.5}`, nil},
+		{"invalid boolean", `{"userId":"user123","solanaAddress":"A1","kycVerified":"maybe"}`, nil},
+		{"number as string field", `{"userId":123,"solanaAddress":"A1"}`, nil},
+		{"missing user", `{"solanaAddress":"A1"}`, nil},
+		{"missing address", `{"userId":"user123"}`, nil},
+		{"future schema version", `{"schemaVersion":3,"userId":"user123","solanaAddress":"A1"}`, nil},
+		{"not JSON", `user123`, nil},
+	}
+	for _, test := range tests {
+		record, err := decodePublicKYCRecord([]byte(test.json))
+		if test.record == nil {
+			if err == nil {
+				t.Errorf("%s: decodePublicKYCRecord = %+v, want an error", test.name, record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: decodePublicKYCRecord failed: %v", test.name, err)
+			continue
+		}
+		if *record != *test.record {
+			t.Errorf("%s: decodePublicKYCRecord = %+v, want %+v", test.name, record, test.record)
+		}
+	}
+}
+
+func TestMigrateRecords(t *testing.T) {
+	stub := &levelDBMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	contract := new(SmartContract)
+	stub.MockTransactionStart("tx1")
+
+	stored := map[string]string{
+		"A1": `{"userId":"user1","solanaAddress":"A1","kycVerified":"true","riskScore":"30","countryCode":"US"}`,
+		"A2": `{"userId":"user2","solanaAddress":"A2","kycVerified":false,"riskScore":10}`,
+		"A3": `{"userId":"user3","solanaAddress":"A3","riskScore":"high"}`,
+		"A4": `{"schemaVersion":2,"docType":"kyc","userId":"user4","solanaAddress":"A4","kycVerified":true,"riskScore":20,"countryCode":"DE"}`,
+		"A5": `{"userId":"user5","solanaAddress":"A5","kycVerified":true,"riskScore":5,"countryCode":"FR","erased":true}`,
+	}
+	for address, publicJSON := range stored {
+		key, err := kycKey(ctx, address)
+		if err != nil {
+			t.Fatalf("kycKey failed: %v", err)
+		}
+		err = stub.PutState(key, []byte(publicJSON))
+		if err != nil {
+			t.Fatalf("failed to put public record: %v", err)
+		}
+	}
+
+	migrated, failed := 0, []string{}
+	bookmark := ""
+	for calls := 0; ; calls++ {
+		if calls > 10 {
+			t.Fatal("MigrateRecords did not finish")
+		}
+		result, err := contract.MigrateRecords(ctx, 1, 2, bookmark)
+		if err != nil {
+			t.Fatalf("MigrateRecords failed: %v", err)
+		}
+		if result.Scanned > 2 || result.FromVersion != 1 || result.ToVersion != publicKYCSchemaVersion {
+			t.Fatalf("MigrateRecords = %+v, want at most 2 records from version 1", result)
+		}
+		migrated += result.Migrated
+		failed = append(failed, result.FailedAddresses...)
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if migrated != 3 || len(failed) != 1 || failed[0] != "A3" {
+		t.Fatalf("migrated %d records, failed %v, want 3 migrated and A3 failed", migrated, failed)
+	}
+
+	record, err := readPublicKYCRecord(ctx, "A1")
+	if err != nil {
+		t.Fatalf("readPublicKYCRecord failed: %v", err)
+	}
+	if record.SchemaVersion != publicKYCSchemaVersion || record.DocType != docTypeKYC || !record.KYCVerified || record.RiskScore != 30 {
+		t.Fatalf("migrated record = %+v, want version %d with typed fields", record, publicKYCSchemaVersion)
+	}
+
+	for _, test := range []struct {
+		country string
+		address string
+		indexed bool
+	}{
+		{"US", "A1", true},
+		{"DE", "A4", false},
+		{"FR", "A5", false},
+	} {
+		key, err := countryIndexKey(ctx, test.country, test.address)
+		if err != nil {
+			t.Fatalf("countryIndexKey failed: %v", err)
+		}
+		value, err := stub.GetState(key)
+		if err != nil {
+			t.Fatalf("failed to read country index: %v", err)
+		}
+		if (value != nil) != test.indexed {
+			t.Errorf("country index entry of %s in %s present %v, want %v", test.address, test.country, value != nil, test.indexed)
+		}
+	}
+
+	if _, err = contract.MigrateRecords(ctx, publicKYCSchemaVersion, 2, ""); err == nil {
+		t.Error("MigrateRecords accepted the current schema version")
+	}
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}})
+	if _, err = contract.MigrateRecords(ctx, 1, 2, ""); err == nil {
+		t.Error("MigrateRecords succeeded for a non-admin client")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Document types of world state values that are looked up with CouchDB rich queries
+const (
+	docTypeKYC         = "kyc"
+	docTypeTransaction = "transaction"
+)
+
+// Design documents of the CouchDB indexes shipped in META-INF/statedb/couchdb/indexes
+const (
+	kycRiskIndexDoc             = "indexKYCRiskDoc"
+	transactionCurrencyIndexDoc = "indexTransactionCurrencyDoc"
+	transactionAmountIndexDoc   = "indexTransactionAmountDoc"
+)
+
+// TransactionQueryResult structure used for returning paginated transaction query results
+type TransactionQueryResult struct {
+	Records             []*TransactionRecord `json:"records"`
+	FetchedRecordsCount int32                `json:"fetchedRecordsCount"`
+	Bookmark            string               `json:"bookmark"`
+}
+
+// getQueryResultWithPagination runs a Mango query on the state database. Rich queries
+// are only supported by CouchDB, so on LevelDB peers the query fails instead of falling
+// back to a scan of the whole world state.
+func getQueryResultWithPagination(ctx contractapi.TransactionContextInterface,
+	query map[string]interface{},
+	pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, string, error) {
+
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	queryString, err := json.Marshal(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryString), pageSize, bookmark)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "leveldb") {
+			return nil, "", fmt.Errorf("this query needs a CouchDB state database and is not available on LevelDB peers")
+		}
+		return nil, "", fmt.Errorf("failed to run rich query: %v", err)
+	}
+
+	return resultsIterator, responseMetadata.Bookmark, nil
+}
+
+// queryTransactions runs a Mango query for transaction records
+func queryTransactions(ctx contractapi.TransactionContextInterface,
+	query map[string]interface{},
+	pageSize int32,
+	bookmark string) (*TransactionQueryResult, error) {
+
+	resultsIterator, nextBookmark, err := getQueryResultWithPagination(ctx, query, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &TransactionQueryResult{
+		Records:  []*TransactionRecord{},
+		Bookmark: nextBookmark,
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var transactionRecord TransactionRecord
+		err = json.Unmarshal(queryResponse.Value, &transactionRecord)
+		if err != nil {
+			return nil, err
+		}
+		result.Records = append(result.Records, &transactionRecord)
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+
+	return result, nil
+}
+
+// QueryKYCByRiskRange retrieves a page of public KYC records with a risk score within
+// [minRiskScore, maxRiskScore]. Needs a CouchDB state database.
+func (s *SmartContract) QueryKYCByRiskRange(ctx contractapi.TransactionContextInterface,
+	minRiskScore int,
+	maxRiskScore int,
+	pageSize int32,
+	bookmark string) (*KYCQueryResult, error) {
+
+	if minRiskScore > maxRiskScore {
+		return nil, fmt.Errorf("minRiskScore must not be greater than maxRiskScore")
+	}
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"docType":   docTypeKYC,
+			"riskScore": map[string]interface{}{"$gte": minRiskScore, "$lte": maxRiskScore},
+			"erased":    map[string]interface{}{"$exists": false},
+		},
+		"sort":      []map[string]string{{"docType": "asc"}, {"riskScore": "asc"}},
+		"use_index": []string{"_design/" + kycRiskIndexDoc, "indexKYCRisk"},
+	}
+
+	resultsIterator, nextBookmark, err := getQueryResultWithPagination(ctx, query, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &KYCQueryResult{
+		Records:  []*KYCRecord{},
+		Bookmark: nextBookmark,
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		publicRecord, err := decodePublicKYCRecord(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		result.Records = append(result.Records, publicRecord.kycRecord())
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+
+	return result, nil
+}
+
+// QueryTransactionsByCurrency retrieves a page of the transactions in a source currency
+// with a history timestamp in [startTime, endTime), oldest first. Needs a CouchDB state
+// database.
+func (s *SmartContract) QueryTransactionsByCurrency(ctx contractapi.TransactionContextInterface,
+	currency string,
+	startTime string,
+	endTime string,
+	pageSize int32,
+	bookmark string) (*TransactionQueryResult, error) {
+
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return nil, fmt.Errorf("startTime must be an RFC3339 timestamp")
+	}
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return nil, fmt.Errorf("endTime must be an RFC3339 timestamp")
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("startTime must be before endTime")
+	}
+
+	// The caller's timestamp may be in any zone, so the range is matched on the history
+	// timestamp set by the chaincode, a fixed-width UTC string that sorts chronologically
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"docType":        docTypeTransaction,
+			"sourceCurrency": currency,
+			"historyTimestamp": map[string]interface{}{
+				"$gte": start.UTC().Format(txHistoryTimestampLayout),
+				"$lt":  end.UTC().Format(txHistoryTimestampLayout),
+			},
+		},
+		"sort":      []map[string]string{{"docType": "asc"}, {"sourceCurrency": "asc"}, {"historyTimestamp": "asc"}},
+		"use_index": []string{"_design/" + transactionCurrencyIndexDoc, "indexTransactionCurrency"},
+	}
+
+	return queryTransactions(ctx, query, pageSize, bookmark)
+}
+
+// QueryHighValueTransactions retrieves a page of the transactions of at least minAmount,
+// largest first. Needs a CouchDB state database.
+func (s *SmartContract) QueryHighValueTransactions(ctx contractapi.TransactionContextInterface,
+	minAmount float64,
+	pageSize int32,
+	bookmark string) (*TransactionQueryResult, error) {
+
+	if minAmount < 0 {
+		return nil, fmt.Errorf("minAmount must be a non-negative number")
+	}
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"docType":     docTypeTransaction,
+			"amountValue": map[string]interface{}{"$gte": minAmount},
+		},
+		"sort":      []map[string]string{{"docType": "desc"}, {"amountValue": "desc"}},
+		"use_index": []string{"_design/" + transactionAmountIndexDoc, "indexTransactionAmount"},
+	}
+
+	return queryTransactions(ctx, query, pageSize, bookmark)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// richQueryMockStub records the rich queries it is given and answers them with fixed
+// results, or fails them like a peer running LevelDB
+type richQueryMockStub struct {
+	*shimtest.MockStub
+	levelDB  bool
+	queries  []string
+	results  []*queryresult.KV
+	bookmark string
+}
+
+func (stub *richQueryMockStub) GetQueryResultWithPagination(query string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+
+	if stub.levelDB {
+		return nil, nil, errors.New("ExecuteQuery not supported for leveldb")
+	}
+	stub.queries = append(stub.queries, query)
+	return &privateDataIterator{entries: stub.results},
+		&pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(stub.results)), Bookmark: stub.bookmark}, nil
+}
+
+// testRichQuery is a decoded Mango query
+type testRichQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []map[string]string    `json:"sort"`
+	UseIndex []string               `json:"use_index"`
+}
+
+// lastTestRichQuery decodes the last query run on the stub
+func lastTestRichQuery(t *testing.T, stub *richQueryMockStub) *testRichQuery {
+	if len(stub.queries) == 0 {
+		t.Fatal("no rich query was run")
+	}
+	var query testRichQuery
+	err := json.Unmarshal([]byte(stub.queries[len(stub.queries)-1]), &query)
+	if err != nil {
+		t.Fatalf("failed to decode rich query: %v", err)
+	}
+
+	return &query
+}
+
+// checkTestQueryIndex checks that a query names a shipped CouchDB index whose fields are the
+// query's sort fields, so that CouchDB can serve the sort from the index
+func checkTestQueryIndex(t *testing.T, query *testRichQuery) {
+	if len(query.UseIndex) != 2 {
+		t.Fatalf("use_index = %v, want a design document and an index name", query.UseIndex)
+	}
+	ddoc := strings.TrimPrefix(query.UseIndex[0], "_design/")
+
+	indexJSON, err := ioutil.ReadFile(filepath.Join("META-INF", "statedb", "couchdb", "indexes", query.UseIndex[1]+".json"))
+	if err != nil {
+		t.Fatalf("index %s is not shipped: %v", query.UseIndex[1], err)
+	}
+	var index struct {
+		Index struct {
+			Fields []string `json:"fields"`
+		} `json:"index"`
+		Ddoc string `json:"ddoc"`
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(indexJSON, &index)
+	if err != nil {
+		t.Fatalf("failed to decode index %s: %v", query.UseIndex[1], err)
+	}
+	if index.Ddoc != ddoc || index.Name != query.UseIndex[1] {
+		t.Errorf("index file declares %s/%s, query uses %s/%s", index.Ddoc, index.Name, ddoc, query.UseIndex[1])
+	}
+
+	sortFields := []string{}
+	for _, sort := range query.Sort {
+		for field := range sort {
+			sortFields = append(sortFields, field)
+		}
+	}
+	if strings.Join(sortFields, ",") != strings.Join(index.Index.Fields, ",") {
+		t.Errorf("query sorts on %v, index %s covers %v", sortFields, index.Name, index.Index.Fields)
+	}
+}
+
+func newRichQueryTestContext() (*TransactionContext, *richQueryMockStub) {
+	stub := &richQueryMockStub{MockStub: shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	return ctx, stub
+}
+
+func TestQueryKYCByRiskRange(t *testing.T) {
+	ctx, stub := newRichQueryTestContext()
+	contract := new(SmartContract)
+
+	publicJSON, _ := json.Marshal(&PublicKYCRecord{UserID: "user123", SolanaAddress: "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE", RiskScore: 80, Tier: TierIDVerified})
+	stub.results = []*queryresult.KV{{Key: "kyc", Value: publicJSON}}
+	stub.bookmark = "next"
+
+	result, err := contract.QueryKYCByRiskRange(ctx, 70, 90, 10, "")
+	if err != nil {
+		t.Fatalf("QueryKYCByRiskRange failed: %v", err)
+	}
+	if len(result.Records) != 1 || result.Records[0].UserID != "user123" || result.FetchedRecordsCount != 1 || result.Bookmark != "next" {
+		t.Fatalf("QueryKYCByRiskRange = %+v, want the record and the next bookmark", result)
+	}
+
+	query := lastTestRichQuery(t, stub)
+	if query.Selector["docType"] != docTypeKYC {
+		t.Errorf("selector docType = %v, want %s", query.Selector["docType"], docTypeKYC)
+	}
+	riskScore, _ := query.Selector["riskScore"].(map[string]interface{})
+	if riskScore["$gte"] != float64(70) || riskScore["$lte"] != float64(90) {
+		t.Errorf("selector riskScore = %v, want [70, 90]", query.Selector["riskScore"])
+	}
+	if _, ok := query.Selector["erased"]; !ok {
+		t.Error("selector does not leave out erased records")
+	}
+	checkTestQueryIndex(t, query)
+
+	if _, err = contract.QueryKYCByRiskRange(ctx, 90, 70, 10, ""); err == nil {
+		t.Error("QueryKYCByRiskRange accepted an inverted range")
+	}
+	if _, err = contract.QueryKYCByRiskRange(ctx, 70, 90, 0, ""); err == nil {
+		t.Error("QueryKYCByRiskRange accepted a zero pageSize")
+	}
+}
+
+func TestQueryTransactionsByCurrency(t *testing.T) {
+	ctx, stub := newRichQueryTestContext()
+	contract := new(SmartContract)
+
+	_, err := contract.QueryTransactionsByCurrency(ctx, "USD", "2025-06-01T02:00:00+02:00", "2025-06-02T00:00:00Z", 10, "")
+	if err != nil {
+		t.Fatalf("QueryTransactionsByCurrency failed: %v", err)
+	}
+	query := lastTestRichQuery(t, stub)
+	if query.Selector["docType"] != docTypeTransaction || query.Selector["sourceCurrency"] != "USD" {
+		t.Errorf("selector = %v, want USD transactions", query.Selector)
+	}
+	historyTimestamp, _ := query.Selector["historyTimestamp"].(map[string]interface{})
+	if historyTimestamp["$gte"] != "2025-06-01T00:00:00.000000000Z" || historyTimestamp["$lt"] != "2025-06-02T00:00:00.000000000Z" {
+		t.Errorf("selector historyTimestamp = %v, want the UTC range of the history index", historyTimestamp)
+	}
+	checkTestQueryIndex(t, query)
+
+	for _, bounds := range [][2]string{
+		{"yesterday", "2025-06-02T00:00:00Z"},
+		{"2025-06-01T00:00:00Z", "today"},
+		{"2025-06-02T00:00:00Z", "2025-06-02T00:00:00Z"},
+	} {
+		_, err = contract.QueryTransactionsByCurrency(ctx, "USD", bounds[0], bounds[1], 10, "")
+		if err == nil {
+			t.Errorf("QueryTransactionsByCurrency accepted the range %s to %s", bounds[0], bounds[1])
+		}
+	}
+}
+
+func TestQueryHighValueTransactions(t *testing.T) {
+	ctx, stub := newRichQueryTestContext()
+	contract := new(SmartContract)
+
+	transactionJSON, _ := json.Marshal(&TransactionRecord{TransactionID: "t1", Amount: "5000", AmountValue: 5000})
+	stub.results = []*queryresult.KV{{Key: "tx", Value: transactionJSON}}
+
+	result, err := contract.QueryHighValueTransactions(ctx, 1000, 10, "")
+	if err != nil {
+		t.Fatalf("QueryHighValueTransactions failed: %v", err)
+	}
+	if len(result.Records) != 1 || result.Records[0].TransactionID != "t1" {
+		t.Fatalf("QueryHighValueTransactions = %+v, want t1", result)
+	}
+	query := lastTestRichQuery(t, stub)
+	amountValue, _ := query.Selector["amountValue"].(map[string]interface{})
+	if query.Selector["docType"] != docTypeTransaction || amountValue["$gte"] != float64(1000) {
+		t.Errorf("selector = %v, want transactions of at least 1000", query.Selector)
+	}
+	checkTestQueryIndex(t, query)
+
+	if _, err = contract.QueryHighValueTransactions(ctx, -1, 10, ""); err == nil {
+		t.Error("QueryHighValueTransactions accepted a negative minAmount")
+	}
+
+	stub.levelDB = true
+	_, err = contract.QueryHighValueTransactions(ctx, 1000, 10, "")
+	if err == nil || !strings.Contains(err.Error(), "CouchDB") {
+		t.Errorf("QueryHighValueTransactions on LevelDB = %v, want an error asking for CouchDB", err)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ruleSetObjectType is the composite key object type of compliance rule sets
+const ruleSetObjectType = "ruleSet"
+
+// activeRuleSetConfig holds the activation state of compliance rule sets
+const activeRuleSetConfig = "activeRuleSet"
+
+// currencyCodePattern matches ISO 4217 currency codes
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ComplianceRule rejects a transaction whose amount exceeds MaxAmount when the
+// sender's risk score lies within [MinRiskScore, MaxRiskScore] and the transaction
+// matches Currency and its destination country matches Country. An empty Currency or
+// Country matches any value, and a transaction without a destination country matches
+// every Country.
+type ComplianceRule struct {
+	ID           string  `json:"id"`
+	Description  string  `json:"description,omitempty"`
+	MinRiskScore int     `json:"minRiskScore"`
+	MaxRiskScore int     `json:"maxRiskScore"`
+	Currency     string  `json:"currency,omitempty"`
+	Country      string  `json:"country,omitempty"`
+	MaxAmount    float64 `json:"maxAmount"`
+}
+
+// RuleSet is a versioned policy document of compliance rules. Rule sets are
+// immutable once created; policy changes are made by creating a new version.
+type RuleSet struct {
+	Version     int               `json:"version"`
+	Description string            `json:"description,omitempty"`
+	Rules       []*ComplianceRule `json:"rules"`
+	CreatedBy   string            `json:"createdBy,omitempty"`
+	CreatedAt   string            `json:"createdAt,omitempty"`
+}
+
+// ruleSetActivation tracks the latest rule set version and the activation history used for rollback
+type ruleSetActivation struct {
+	LatestVersion int   `json:"latestVersion"`
+	History       []int `json:"history"`
+}
+
+// defaultRuleSet applies until a rule set has been activated. It reproduces
+// the limit the chaincode enforced before rule sets were configurable.
+var defaultRuleSet = RuleSet{
+	Version:     0,
+	Description: "Built-in default rules",
+	Rules: []*ComplianceRule{
+		{
+			ID:           "default-high-risk",
+			Description:  "Transaction amount exceeds limit for high-risk user",
+			MinRiskScore: 71,
+			MaxRiskScore: 100,
+			MaxAmount:    1000,
+		},
+	},
+}
+
+// validate checks the rules of a rule set for consistency
+func (ruleSet *RuleSet) validate() error {
+	if len(ruleSet.Rules) == 0 {
+		return fmt.Errorf("rule set must contain at least one rule")
+	}
+
+	ruleIDs := make(map[string]bool)
+	for _, rule := range ruleSet.Rules {
+		if rule == nil || len(rule.ID) == 0 {
+			return fmt.Errorf("every rule must have a non-empty id")
+		}
+		if ruleIDs[rule.ID] {
+			return fmt.Errorf("duplicate rule id %s", rule.ID)
+		}
+		ruleIDs[rule.ID] = true
+
+		if rule.MinRiskScore < 0 || rule.MaxRiskScore > 100 || rule.MinRiskScore > rule.MaxRiskScore {
+			return fmt.Errorf("rule %s must have a risk band within 0 and 100", rule.ID)
+		}
+		if rule.Currency != "" && !currencyCodePattern.MatchString(rule.Currency) {
+			return fmt.Errorf("rule %s currency must be an ISO 4217 code", rule.ID)
+		}
+		if rule.Country != "" && !countryCodePattern.MatchString(rule.Country) {
+			return fmt.Errorf("rule %s country must be an ISO 3166-1 alpha-2 code", rule.ID)
+		}
+		if rule.MaxAmount < 0 {
+			return fmt.Errorf("rule %s maxAmount must not be negative", rule.ID)
+		}
+	}
+
+	return nil
+}
+
+// evaluate returns the first rule rejecting the transaction, or nil when every rule passes
+func (ruleSet *RuleSet) evaluate(riskScore int, transactionData *TransactionValidation) *ComplianceRule {
+	for _, rule := range ruleSet.Rules {
+		if riskScore < rule.MinRiskScore || riskScore > rule.MaxRiskScore {
+			continue
+		}
+		if rule.Currency != "" && rule.Currency != transactionData.Currency {
+			continue
+		}
+		// An undeclared destination country cannot escape a country rule
+		if rule.Country != "" && transactionData.DestinationCountry != "" && rule.Country != transactionData.DestinationCountry {
+			continue
+		}
+		if transactionData.Amount > rule.MaxAmount {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// getRuleSetActivation reads the rule set activation state
+func getRuleSetActivation(ctx contractapi.TransactionContextInterface) (*ruleSetActivation, error) {
+	activation := ruleSetActivation{
+		History: []int{},
+	}
+	_, err := getConfig(ctx, activeRuleSetConfig, &activation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &activation, nil
+}
+
+// ruleSetKey builds the composite key of a rule set version
+func ruleSetKey(ctx contractapi.TransactionContextInterface, version int) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(ruleSetObjectType, []string{fmt.Sprintf("%010d", version)})
+	if err != nil {
+		return "", fmt.Errorf("failed to create rule set key: %v", err)
+	}
+
+	return key, nil
+}
+
+// readRuleSet reads a rule set version from the world state
+func readRuleSet(ctx contractapi.TransactionContextInterface, version int) (*RuleSet, error) {
+	key, err := ruleSetKey(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleSetJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule set %d: %v", version, err)
+	}
+	if ruleSetJSON == nil {
+		return nil, fmt.Errorf("rule set %d does not exist", version)
+	}
+
+	var ruleSet RuleSet
+	err = json.Unmarshal(ruleSetJSON, &ruleSet)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ruleSet, nil
+}
+
+// getActiveRuleSet returns the active rule set, or the built-in default rules if none was activated
+func getActiveRuleSet(ctx contractapi.TransactionContextInterface) (*RuleSet, error) {
+	activation, err := getRuleSetActivation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(activation.History) == 0 {
+		return &defaultRuleSet, nil
+	}
+
+	return readRuleSet(ctx, activation.History[len(activation.History)-1])
+}
+
+// CreateRuleSet stores a new version of the compliance rules and returns its version number.
+// The rule set only takes effect once activated with ActivateRuleSet.
+func (s *SmartContract) CreateRuleSet(ctx contractapi.TransactionContextInterface,
+	ruleSetJSON string) (int, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var ruleSet RuleSet
+	err = json.Unmarshal([]byte(ruleSetJSON), &ruleSet)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal rule set JSON: %v", err)
+	}
+
+	err = ruleSet.validate()
+	if err != nil {
+		return 0, err
+	}
+
+	activation, err := getRuleSetActivation(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read client identity: %v", err)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	activation.LatestVersion++
+	ruleSet.Version = activation.LatestVersion
+	ruleSet.CreatedBy = clientID
+	ruleSet.CreatedAt = now.Format(time.RFC3339)
+
+	key, err := ruleSetKey(ctx, ruleSet.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	storedJSON, err := json.Marshal(ruleSet)
+	if err != nil {
+		return 0, err
+	}
+
+	err = ctx.GetStub().PutState(key, storedJSON)
+	if err != nil {
+		return 0, fmt.Errorf("failed to put rule set: %v", err)
+	}
+
+	err = putConfig(ctx, activeRuleSetConfig, activation)
+	if err != nil {
+		return 0, err
+	}
+
+	return ruleSet.Version, nil
+}
+
+// ActivateRuleSet makes a stored rule set version the one evaluated by ValidateTransaction
+func (s *SmartContract) ActivateRuleSet(ctx contractapi.TransactionContextInterface,
+	version int) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = readRuleSet(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	activation, err := getRuleSetActivation(ctx)
+	if err != nil {
+		return err
+	}
+	if len(activation.History) > 0 && activation.History[len(activation.History)-1] == version {
+		return fmt.Errorf("rule set %d is already active", version)
+	}
+
+	activation.History = append(activation.History, version)
+
+	return putConfig(ctx, activeRuleSetConfig, activation)
+}
+
+// RollbackRuleSet reactivates the rule set that was active before the current one.
+// Rolling back the first activated rule set restores the built-in default rules.
+func (s *SmartContract) RollbackRuleSet(ctx contractapi.TransactionContextInterface) (int, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	activation, err := getRuleSetActivation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(activation.History) == 0 {
+		return 0, fmt.Errorf("no rule set has been activated")
+	}
+
+	activation.History = activation.History[:len(activation.History)-1]
+
+	err = putConfig(ctx, activeRuleSetConfig, activation)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(activation.History) == 0 {
+		return defaultRuleSet.Version, nil
+	}
+
+	return activation.History[len(activation.History)-1], nil
+}
+
+// GetActiveRuleSet returns the rule set currently evaluated by ValidateTransaction
+func (s *SmartContract) GetActiveRuleSet(ctx contractapi.TransactionContextInterface) (*RuleSet, error) {
+	return getActiveRuleSet(ctx)
+}
+
+// GetRuleSet returns a stored rule set version
+func (s *SmartContract) GetRuleSet(ctx contractapi.TransactionContextInterface,
+	version int) (*RuleSet, error) {
+
+	return readRuleSet(ctx, version)
+}
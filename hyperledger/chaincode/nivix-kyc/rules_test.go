@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestRuleSetValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []*ComplianceRule
+		valid bool
+	}{
+		{"valid rules", []*ComplianceRule{
+			{ID: "high-risk", MinRiskScore: 71, MaxRiskScore: 100, MaxAmount: 1000},
+			{ID: "usd-ir", MaxRiskScore: 100, Currency: "USD", Country: "IR", MaxAmount: 0},
+		}, true},
+		{"no rules", []*ComplianceRule{}, false},
+		{"nil rule", []*ComplianceRule{nil}, false},
+		{"missing id", []*ComplianceRule{{MaxRiskScore: 100, MaxAmount: 1}}, false},
+		{"duplicate id", []*ComplianceRule{{ID: "a", MaxRiskScore: 100}, {ID: "a", MaxRiskScore: 100}}, false},
+		{"inverted risk band", []*ComplianceRule{{ID: "a", MinRiskScore: 80, MaxRiskScore: 70}}, false},
+		{"risk band above 100", []*ComplianceRule{{ID: "a", MaxRiskScore: 101}}, false},
+		{"lowercase currency", []*ComplianceRule{{ID: "a", MaxRiskScore: 100, Currency: "usd"}}, false},
+		{"invalid country", []*ComplianceRule{{ID: "a", MaxRiskScore: 100, Country: "USA"}}, false},
+		{"negative amount", []*ComplianceRule{{ID: "a", MaxRiskScore: 100, MaxAmount: -1}}, false},
+	}
+	for _, test := range tests {
+		ruleSet := &RuleSet{Rules: test.rules}
+		err := ruleSet.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestRuleSetEvaluate(t *testing.T) {
+	ruleSet := &RuleSet{Rules: []*ComplianceRule{
+		{ID: "sanctioned-country", MaxRiskScore: 100, Country: "IR", MaxAmount: 0},
+		{ID: "eur-limit", MaxRiskScore: 100, Currency: "EUR", MaxAmount: 500},
+		{ID: "high-risk", MinRiskScore: 71, MaxRiskScore: 100, MaxAmount: 1000},
+	}}
+
+	tests := []struct {
+		name      string
+		riskScore int
+		data      TransactionValidation
+		ruleID    string
+	}{
+		{"low risk within limits", 10, TransactionValidation{Amount: 400, Currency: "USD", DestinationCountry: "US"}, ""},
+		{"country rule", 10, TransactionValidation{Amount: 1, Currency: "USD", DestinationCountry: "IR"}, "sanctioned-country"},
+		{"undeclared country", 10, TransactionValidation{Amount: 1, Currency: "USD"}, "sanctioned-country"},
+		{"currency rule", 10, TransactionValidation{Amount: 600, Currency: "EUR", DestinationCountry: "DE"}, "eur-limit"},
+		{"currency rule of another currency", 10, TransactionValidation{Amount: 600, Currency: "USD", DestinationCountry: "DE"}, ""},
+		{"high risk within limits", 80, TransactionValidation{Amount: 1000, Currency: "USD", DestinationCountry: "US"}, ""},
+		{"high risk over the limit", 80, TransactionValidation{Amount: 1001, Currency: "USD", DestinationCountry: "US"}, "high-risk"},
+		{"risk band boundary", 70, TransactionValidation{Amount: 5000, Currency: "USD", DestinationCountry: "US"}, ""},
+	}
+	for _, test := range tests {
+		ruleID := ""
+		if rule := ruleSet.evaluate(test.riskScore, &test.data); rule != nil {
+			ruleID = rule.ID
+		}
+		if ruleID != test.ruleID {
+			t.Errorf("%s: evaluate = %q, want %q", test.name, ruleID, test.ruleID)
+		}
+	}
+}
+
+func TestRuleSetActivationAndRollback(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	contract := new(SmartContract)
+	stub.MockTransactionStart("tx1")
+
+	activeVersion := func() int {
+		ruleSet, err := contract.GetActiveRuleSet(ctx)
+		if err != nil {
+			t.Fatalf("GetActiveRuleSet failed: %v", err)
+		}
+		return ruleSet.Version
+	}
+	if activeVersion() != defaultRuleSet.Version {
+		t.Fatal("the default rule set is not active before any activation")
+	}
+
+	_, err := contract.CreateRuleSet(ctx, `{"rules":[{"id":"a","maxRiskScore":100,"maxAmount":-1}]}`)
+	if err == nil {
+		t.Fatal("CreateRuleSet accepted an invalid rule set")
+	}
+	first, err := contract.CreateRuleSet(ctx, `{"version":7,"rules":[{"id":"a","maxRiskScore":100,"maxAmount":100}]}`)
+	if err != nil {
+		t.Fatalf("CreateRuleSet failed: %v", err)
+	}
+	second, err := contract.CreateRuleSet(ctx, `{"rules":[{"id":"b","maxRiskScore":100,"maxAmount":200}]}`)
+	if err != nil {
+		t.Fatalf("CreateRuleSet failed: %v", err)
+	}
+	if first != 1 || second != 2 {
+		t.Fatalf("created versions %d and %d, want 1 and 2", first, second)
+	}
+	ruleSet, err := contract.GetRuleSet(ctx, first)
+	if err != nil {
+		t.Fatalf("GetRuleSet failed: %v", err)
+	}
+	if ruleSet.Version != 1 || ruleSet.CreatedBy == "" || ruleSet.CreatedAt == "" {
+		t.Fatalf("stored rule set = %+v, want version 1 with its creator and time", ruleSet)
+	}
+	if activeVersion() != defaultRuleSet.Version {
+		t.Fatal("creating a rule set activated it")
+	}
+
+	for _, version := range []int{first, second} {
+		err = contract.ActivateRuleSet(ctx, version)
+		if err != nil {
+			t.Fatalf("ActivateRuleSet(%d) failed: %v", version, err)
+		}
+	}
+	if err = contract.ActivateRuleSet(ctx, second); err == nil {
+		t.Fatal("ActivateRuleSet reactivated the active rule set")
+	}
+	if err = contract.ActivateRuleSet(ctx, 3); err == nil {
+		t.Fatal("ActivateRuleSet activated a rule set that does not exist")
+	}
+	if activeVersion() != second {
+		t.Fatalf("active rule set %d, want %d", activeVersion(), second)
+	}
+
+	for _, want := range []int{first, defaultRuleSet.Version} {
+		version, err := contract.RollbackRuleSet(ctx)
+		if err != nil {
+			t.Fatalf("RollbackRuleSet failed: %v", err)
+		}
+		if version != want || activeVersion() != want {
+			t.Fatalf("rolled back to %d, active %d, want %d", version, activeVersion(), want)
+		}
+	}
+	if _, err = contract.RollbackRuleSet(ctx); err == nil {
+		t.Fatal("RollbackRuleSet succeeded without an activated rule set")
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}})
+	if _, err = contract.CreateRuleSet(ctx, `{"rules":[{"id":"c","maxRiskScore":100,"maxAmount":1}]}`); err == nil {
+		t.Fatal("CreateRuleSet succeeded for a non-admin client")
+	}
+	if err = contract.ActivateRuleSet(ctx, first); err == nil {
+		t.Fatal("ActivateRuleSet succeeded for a non-admin client")
+	}
+}
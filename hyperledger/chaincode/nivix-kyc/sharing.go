@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// kycShareObjectType is the composite key object type of the public register of KYC shares
+const kycShareObjectType = "kycShare~user~org"
+
+// sharedKYCObjectType is the composite key object type of shared KYC subsets in a
+// partner's implicit org collection
+const sharedKYCObjectType = "sharedKYC"
+
+// KYC share statuses
+const (
+	ShareActive  = "ACTIVE"
+	ShareRevoked = "REVOKED"
+)
+
+// shareableKYCFields lists the KYC record fields that may be shared with a partner
+var shareableKYCFields = map[string]bool{
+	"solanaAddress":    true,
+	"fullName":         true,
+	"dateOfBirth":      true,
+	"countryCode":      true,
+	"kycVerified":      true,
+	"tier":             true,
+	"verificationDate": true,
+	"expiryDate":       true,
+	"riskScore":        true,
+	"screeningStatus":  true,
+}
+
+// SharedKYCRecord is the subset of a customer's KYC record shared with a partner organization.
+// It is stored in the partner's implicit org collection.
+type SharedKYCRecord struct {
+	UserID     string                 `json:"userId"`
+	SharedBy   string                 `json:"sharedBy"`
+	SharedWith string                 `json:"sharedWith"`
+	SharedAt   string                 `json:"sharedAt"`
+	TxID       string                 `json:"txId"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// KYCShare is the public, non-identifying record that a customer's KYC subset was shared
+// with a partner organization
+type KYCShare struct {
+	UserID           string   `json:"userId"`
+	PartnerMSPID     string   `json:"partnerMspId"`
+	SharedBy         string   `json:"sharedBy"`
+	Fields           []string `json:"fields"`
+	Status           string   `json:"status"`
+	SharedAt         string   `json:"sharedAt"`
+	RevokedAt        string   `json:"revokedAt,omitempty"`
+	RevocationReason string   `json:"revocationReason,omitempty"`
+}
+
+// kycShareKey builds the composite key of a KYC share
+func kycShareKey(ctx contractapi.TransactionContextInterface, userId string, partnerMSPID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(kycShareObjectType, []string{userId, partnerMSPID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create KYC share key: %v", err)
+	}
+
+	return key, nil
+}
+
+// sharedKYCKey builds the key of a shared KYC subset in the partner's implicit collection
+func sharedKYCKey(ctx contractapi.TransactionContextInterface, userId string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(sharedKYCObjectType, []string{userId})
+	if err != nil {
+		return "", fmt.Errorf("failed to create shared KYC key: %v", err)
+	}
+
+	return key, nil
+}
+
+// readKYCShare reads the KYC share of a user with a partner, returning nil if there is none
+func readKYCShare(ctx contractapi.TransactionContextInterface, userId string, partnerMSPID string) (*KYCShare, error) {
+	key, err := kycShareKey(ctx, userId, partnerMSPID)
+	if err != nil {
+		return nil, err
+	}
+
+	shareJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC share: %v", err)
+	}
+	if shareJSON == nil {
+		return nil, nil
+	}
+
+	var share KYCShare
+	err = json.Unmarshal(shareJSON, &share)
+	if err != nil {
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+// putKYCShare writes a KYC share to the world state
+func putKYCShare(ctx contractapi.TransactionContextInterface, share *KYCShare) error {
+	key, err := kycShareKey(ctx, share.UserID, share.PartnerMSPID)
+	if err != nil {
+		return err
+	}
+
+	shareJSON, err := json.Marshal(share)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, shareJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put KYC share: %v", err)
+	}
+
+	return nil
+}
+
+// getKYCShares returns every KYC share of a user
+func getKYCShares(ctx contractapi.TransactionContextInterface, userId string) ([]*KYCShare, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(kycShareObjectType, []string{userId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC shares: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	shares := []*KYCShare{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var share KYCShare
+		err = json.Unmarshal(queryResponse.Value, &share)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, &share)
+	}
+
+	return shares, nil
+}
+
+// parseShareFields decodes and validates the JSON array of fields to share
+func parseShareFields(fieldsJSON string) ([]string, error) {
+	var fields []string
+	err := json.Unmarshal([]byte(fieldsJSON), &fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fields JSON: %v", err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("at least one field must be shared")
+	}
+
+	seen := make(map[string]bool, len(fields))
+	unique := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !shareableKYCFields[field] {
+			return nil, fmt.Errorf("field %s cannot be shared", field)
+		}
+		if !seen[field] {
+			seen[field] = true
+			unique = append(unique, field)
+		}
+	}
+	sort.Strings(unique)
+
+	return unique, nil
+}
+
+// revokeKYCShare purges a shared KYC subset from the partner's implicit collection,
+// marks the share revoked and logs a compliance event
+func (s *SmartContract) revokeKYCShare(ctx contractapi.TransactionContextInterface, share *KYCShare, reason string) error {
+	key, err := sharedKYCKey(ctx, share.UserID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PurgePrivateData(implicitCollectionName(share.PartnerMSPID), key)
+	if err != nil {
+		return fmt.Errorf("failed to purge shared KYC data of %s: %v", share.PartnerMSPID, err)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	share.Status = ShareRevoked
+	share.RevokedAt = now.Format(time.RFC3339)
+	share.RevocationReason = reason
+	err = putKYCShare(ctx, share)
+	if err != nil {
+		return err
+	}
+
+	return s.RecordComplianceEvent(ctx, share.UserID, "KYC Share Revocation",
+		fmt.Sprintf("KYC data share with %s revoked: %s", share.PartnerMSPID, reason))
+}
+
+// ShareKYCWithOrg shares a subset of a customer's KYC record with a partner organization,
+// such as the receiving institution of a payment corridor. The subset is written to the
+// partner's implicit org collection, so that only the partner's peers hold it, and the
+// share is registered in the world state. fieldsJSON is a JSON array of field names.
+// Only admins of kycPrivateData member orgs can share a record.
+func (s *SmartContract) ShareKYCWithOrg(ctx contractapi.TransactionContextInterface,
+	userId string,
+	partnerMSPID string,
+	fieldsJSON string) (*KYCShare, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(partnerMSPID) == 0 {
+		return nil, fmt.Errorf("partnerMSPID must be a non-empty string")
+	}
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	if partnerMSPID == clientMSPID {
+		return nil, fmt.Errorf("KYC data cannot be shared with the client's own org %s", clientMSPID)
+	}
+
+	fields, err := parseShareFields(fieldsJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	kycRecord, err := readPrivateKYCRecord(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = applyExpiry(ctx, kycRecord)
+	if err != nil {
+		return nil, err
+	}
+	kycRecord.Tier = effectiveTier(kycRecord)
+
+	recordJSON, err := json.Marshal(kycRecord)
+	if err != nil {
+		return nil, err
+	}
+	var recordFields map[string]interface{}
+	err = json.Unmarshal(recordJSON, &recordFields)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shared := &SharedKYCRecord{
+		UserID:     userId,
+		SharedBy:   clientMSPID,
+		SharedWith: partnerMSPID,
+		SharedAt:   now.Format(time.RFC3339),
+		TxID:       ctx.GetStub().GetTxID(),
+		Fields:     make(map[string]interface{}, len(fields)),
+	}
+	for _, field := range fields {
+		if value, ok := recordFields[field]; ok {
+			shared.Fields[field] = value
+		}
+	}
+
+	sharedJSON, err := json.Marshal(shared)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sharedKYCKey(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutPrivateData(implicitCollectionName(partnerMSPID), key, sharedJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put shared KYC data for %s: %v", partnerMSPID, err)
+	}
+
+	share := &KYCShare{
+		UserID:       userId,
+		PartnerMSPID: partnerMSPID,
+		SharedBy:     clientMSPID,
+		Fields:       fields,
+		Status:       ShareActive,
+		SharedAt:     shared.SharedAt,
+	}
+	err = putKYCShare(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.RecordComplianceEvent(ctx, userId, "KYC Share",
+		fmt.Sprintf("KYC fields %s shared with %s", strings.Join(fields, ", "), partnerMSPID))
+	if err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+// RevokeKYCShare withdraws a customer's KYC data from a partner organization. The shared
+// subset is purged from the partner's implicit org collection, including its history.
+// Only admins can revoke a share.
+func (s *SmartContract) RevokeKYCShare(ctx contractapi.TransactionContextInterface,
+	userId string,
+	partnerMSPID string,
+	reason string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(reason) == 0 {
+		return fmt.Errorf("reason must be a non-empty string")
+	}
+
+	share, err := readKYCShare(ctx, userId, partnerMSPID)
+	if err != nil {
+		return err
+	}
+	if share == nil || share.Status != ShareActive {
+		return fmt.Errorf("KYC data of user %s is not shared with %s", userId, partnerMSPID)
+	}
+
+	return s.revokeKYCShare(ctx, share, reason)
+}
+
+// GetKYCShares returns the organizations a customer's KYC data has been shared with
+func (s *SmartContract) GetKYCShares(ctx contractapi.TransactionContextInterface,
+	userId string) ([]*KYCShare, error) {
+
+	return getKYCShares(ctx, userId)
+}
+
+// ReadSharedKYC returns the KYC subset shared with the client's org from its implicit collection
+func (s *SmartContract) ReadSharedKYC(ctx contractapi.TransactionContextInterface,
+	userId string) (*SharedKYCRecord, error) {
+
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+
+	key, err := sharedKYCKey(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	sharedJSON, err := ctx.GetStub().GetPrivateData(implicitCollectionName(clientMSPID), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shared KYC data: %v", err)
+	}
+	if sharedJSON == nil {
+		return nil, fmt.Errorf("no KYC data of user %s is shared with %s", userId, clientMSPID)
+	}
+
+	var shared SharedKYCRecord
+	err = json.Unmarshal(sharedJSON, &shared)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shared, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// testClientIdentity is a client identity with a fixed MSP, OUs and attributes
+type testClientIdentity struct {
+	mspID string
+	ou    []string
+	attrs map[string]string
+}
+
+func (c *testClientIdentity) GetID() (string, error) {
+	return "x509::CN=" + c.mspID + "-client", nil
+}
+
+func (c *testClientIdentity) GetMSPID() (string, error) {
+	return c.mspID, nil
+}
+
+func (c *testClientIdentity) GetAttributeValue(name string) (string, bool, error) {
+	value, found := c.attrs[name]
+	return value, found, nil
+}
+
+func (c *testClientIdentity) AssertAttributeValue(name, value string) error {
+	if c.attrs[name] != value {
+		return fmt.Errorf("attribute %s does not have value %s", name, value)
+	}
+	return nil
+}
+
+func (c *testClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: c.ou}}, nil
+}
+
+func TestKYCSharingRequiresAdmin(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+
+	stub.MockTransactionStart("tx1")
+	putTestKYCRecord(t, stub.MockStub, &KYCRecord{
+		UserID:        "user123",
+		SolanaAddress: "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		FullName:      "John Doe",
+		CountryCode:   "US",
+	})
+
+	clients := []struct {
+		name     string
+		identity *testClientIdentity
+	}{
+		{"member", &testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}}},
+		{"bridge", &testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}, attrs: map[string]string{"nivix.bridge": "true"}}},
+		{"admin of another org", &testClientIdentity{mspID: "Org3MSP", ou: []string{"admin"}}},
+	}
+
+	for _, client := range clients {
+		ctx.SetClientIdentity(client.identity)
+		_, err := contract.ShareKYCWithOrg(ctx, "user123", "Org3MSP", `["fullName"]`)
+		if err == nil {
+			t.Errorf("%s: ShareKYCWithOrg succeeded, want an authorization error", client.name)
+		}
+		err = contract.RevokeKYCShare(ctx, "user123", "Org3MSP", "Corridor closed")
+		if err == nil {
+			t.Errorf("%s: RevokeKYCShare succeeded, want an authorization error", client.name)
+		}
+	}
+
+	shares, err := getKYCShares(ctx, "user123")
+	if err != nil {
+		t.Fatalf("getKYCShares failed: %v", err)
+	}
+	if len(shares) != 0 {
+		t.Fatalf("unauthorized clients registered %d shares", len(shares))
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	_, err = contract.ShareKYCWithOrg(ctx, "user123", "Org3MSP", `["fullName"]`)
+	if err != nil {
+		t.Fatalf("admin: ShareKYCWithOrg failed: %v", err)
+	}
+	err = contract.RevokeKYCShare(ctx, "user123", "Org3MSP", "Corridor closed")
+	if err != nil {
+		t.Fatalf("admin: RevokeKYCShare failed: %v", err)
+	}
+}
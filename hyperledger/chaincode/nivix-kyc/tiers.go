@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// tierLimitsConfig holds the transaction and balance limits of each KYC tier
+const tierLimitsConfig = "tierLimits"
+
+// KYC tiers, from least to most verified
+const (
+	TierNone                 = "NONE"
+	TierPhoneVerified        = "PHONE_VERIFIED"
+	TierIDVerified           = "ID_VERIFIED"
+	TierAddressVerified      = "ADDRESS_VERIFIED"
+	TierEnhancedDueDiligence = "ENHANCED_DUE_DILIGENCE"
+)
+
+// tierLevels orders the KYC tiers
+var tierLevels = map[string]int{
+	TierNone:                 0,
+	TierPhoneVerified:        1,
+	TierIDVerified:           2,
+	TierAddressVerified:      3,
+	TierEnhancedDueDiligence: 4,
+}
+
+// TierLimit caps the single transaction amount and the wallet balance allowed for a KYC tier.
+// A zero limit leaves the amount unlimited.
+type TierLimit struct {
+	Tier                 string  `json:"tier"`
+	MaxTransactionAmount float64 `json:"maxTransactionAmount"`
+	MaxBalance           float64 `json:"maxBalance"`
+}
+
+// defaultTierLimits apply until an admin configures tier limits
+var defaultTierLimits = []*TierLimit{
+	{Tier: TierPhoneVerified, MaxTransactionAmount: 200, MaxBalance: 1000},
+	{Tier: TierIDVerified, MaxTransactionAmount: 2000, MaxBalance: 10000},
+	{Tier: TierAddressVerified, MaxTransactionAmount: 10000, MaxBalance: 50000},
+	{Tier: TierEnhancedDueDiligence, MaxTransactionAmount: 0, MaxBalance: 0},
+}
+
+// effectiveTier returns the tier of a KYC record. Records stored before tiers existed
+// count as address verified when verified, matching the full verification they had.
+func effectiveTier(kycRecord *KYCRecord) string {
+	if kycRecord.Tier != "" {
+		return kycRecord.Tier
+	}
+	if kycRecord.KYCVerified {
+		return TierAddressVerified
+	}
+
+	return TierNone
+}
+
+// isFullyVerified reports whether a tier includes verification of an identity document
+func isFullyVerified(tier string) bool {
+	return tierLevels[tier] >= tierLevels[TierIDVerified]
+}
+
+// getTierLimits reads the configured tier limits, or a copy of the defaults when none are configured
+func getTierLimits(ctx contractapi.TransactionContextInterface) ([]*TierLimit, error) {
+	limits := []*TierLimit{}
+	found, err := getConfig(ctx, tierLimitsConfig, &limits)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		for _, limit := range defaultTierLimits {
+			limitCopy := *limit
+			limits = append(limits, &limitCopy)
+		}
+	}
+
+	return limits, nil
+}
+
+// assignKYCTier sets the tier of a KYC record submitted through StoreKYC or StoreKYCPrivate.
+// Clients cannot choose a tier: an existing user keeps their tier and verification status,
+// which only admins change, and a new user starts at ID_VERIFIED when
+// verified and at NONE otherwise.
+func assignKYCTier(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord) error {
+	existing, err := getPrivateKYCRecord(ctx, kycRecord.UserID)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		kycRecord.Tier = effectiveTier(existing)
+	} else if kycRecord.KYCVerified {
+		kycRecord.Tier = TierIDVerified
+	} else {
+		kycRecord.Tier = TierNone
+	}
+	kycRecord.KYCVerified = isFullyVerified(kycRecord.Tier)
+
+	return nil
+}
+
+// checkTierLimits returns the ID of the tier limit the transaction would exceed, or an empty
+// string. Without a wallet balance, a tier with a balance limit reports tier-balance-required,
+// so that the transaction is rejected rather than allowed past the limit.
+func checkTierLimits(ctx contractapi.TransactionContextInterface, tier string, transactionData *TransactionValidation) (string, error) {
+	limits, err := getTierLimits(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, limit := range limits {
+		if limit.Tier != tier {
+			continue
+		}
+		if limit.MaxTransactionAmount > 0 && transactionData.Amount > limit.MaxTransactionAmount {
+			return "tier-transaction-limit", nil
+		}
+		if limit.MaxBalance > 0 {
+			if transactionData.WalletBalance == nil {
+				return "tier-balance-required", nil
+			}
+			if *transactionData.WalletBalance > limit.MaxBalance {
+				return "tier-balance-limit", nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// changeKYCTier moves a user to a new tier, in the direction allowed by upgrade, and logs a compliance event
+func (s *SmartContract) changeKYCTier(ctx contractapi.TransactionContextInterface, userId string, tier string, reason string, upgrade bool) error {
+	newLevel, ok := tierLevels[tier]
+	if !ok {
+		return fmt.Errorf("unknown KYC tier %s", tier)
+	}
+
+	kycRecord, err := readPrivateKYCRecord(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	currentTier := effectiveTier(kycRecord)
+	currentLevel := tierLevels[currentTier]
+	if upgrade && newLevel <= currentLevel {
+		return fmt.Errorf("tier %s is not an upgrade from %s", tier, currentTier)
+	}
+	if !upgrade && newLevel >= currentLevel {
+		return fmt.Errorf("tier %s is not a downgrade from %s", tier, currentTier)
+	}
+
+	kycRecord.Tier = tier
+	kycRecord.KYCVerified = isFullyVerified(tier)
+	if upgrade {
+		now, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		kycRecord.VerificationDate = now.Format(time.RFC3339)
+	}
+
+	err = s.putKYCRecord(ctx, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = emitKYCEvent(ctx, EventKYCStatusChanged, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	action := "KYC Tier Downgrade"
+	if upgrade {
+		action = "KYC Tier Upgrade"
+	}
+
+	return s.RecordComplianceEvent(ctx, userId, action, fmt.Sprintf("Tier changed from %s to %s: %s", currentTier, tier, reason))
+}
+
+// UpgradeKYCTier moves a user to a higher KYC tier after additional verification. Tier
+// changes are compliance decisions and are restricted to admins.
+func (s *SmartContract) UpgradeKYCTier(ctx contractapi.TransactionContextInterface,
+	userId string,
+	tier string,
+	reason string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.changeKYCTier(ctx, userId, tier, reason, true)
+}
+
+// DowngradeKYCTier moves a user to a lower KYC tier. Only admins may change tiers.
+func (s *SmartContract) DowngradeKYCTier(ctx contractapi.TransactionContextInterface,
+	userId string,
+	tier string,
+	reason string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.changeKYCTier(ctx, userId, tier, reason, false)
+}
+
+// SetTierLimits replaces the transaction and balance limits of the KYC tiers
+func (s *SmartContract) SetTierLimits(ctx contractapi.TransactionContextInterface,
+	limitsJSON string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var limits []*TierLimit
+	err = json.Unmarshal([]byte(limitsJSON), &limits)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal tier limits JSON: %v", err)
+	}
+
+	for _, limit := range limits {
+		if limit == nil {
+			return fmt.Errorf("tier limits must not be null")
+		}
+		if _, ok := tierLevels[limit.Tier]; !ok || limit.Tier == TierNone {
+			return fmt.Errorf("unknown KYC tier %s", limit.Tier)
+		}
+		if limit.MaxTransactionAmount < 0 || limit.MaxBalance < 0 {
+			return fmt.Errorf("tier %s must not have negative limits", limit.Tier)
+		}
+	}
+
+	return putConfig(ctx, tierLimitsConfig, limits)
+}
+
+// GetTierLimits returns the transaction and balance limits of the KYC tiers
+func (s *SmartContract) GetTierLimits(ctx contractapi.TransactionContextInterface) ([]*TierLimit, error) {
+	return getTierLimits(ctx)
+}
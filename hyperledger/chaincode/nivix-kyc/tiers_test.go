@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestGetTierLimitsLeavesDefaultsUnchanged(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	// The configured limits omit maxBalance
+	err := stub.PutState(configTestKey(t, stub, tierLimitsConfig), []byte(`[{"tier":"ID_VERIFIED","maxTransactionAmount":5}]`))
+	if err != nil {
+		t.Fatalf("failed to put config: %v", err)
+	}
+	limits, err := getTierLimits(ctx)
+	if err != nil {
+		t.Fatalf("getTierLimits failed: %v", err)
+	}
+	if len(limits) != 1 || limits[0].Tier != TierIDVerified || limits[0].MaxBalance != 0 {
+		t.Fatalf("getTierLimits = %+v, want only the configured limit", limits[0])
+	}
+	if defaultTierLimits[0].Tier != TierPhoneVerified || defaultTierLimits[0].MaxTransactionAmount != 200 {
+		t.Fatal("reading configured limits changed the default limits")
+	}
+
+	err = stub.DelState(configTestKey(t, stub, tierLimitsConfig))
+	if err != nil {
+		t.Fatalf("failed to delete config: %v", err)
+	}
+	limits, err = getTierLimits(ctx)
+	if err != nil {
+		t.Fatalf("getTierLimits failed: %v", err)
+	}
+	limits[0].MaxTransactionAmount = 1
+	if defaultTierLimits[0].MaxTransactionAmount != 200 {
+		t.Fatal("changing a returned limit changed the default limits")
+	}
+}
+
+func TestParseKYCInputIgnoresClientTier(t *testing.T) {
+	kycRecord, err := parseKYCInput([]byte(`{"userId":"user123","solanaAddress":"8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE","fullName":"John Doe","kycVerified":true,"verificationDate":"2025-05-17T12:00:00Z","riskScore":50,"countryCode":"US","tier":"ENHANCED_DUE_DILIGENCE"}`))
+	if err != nil {
+		t.Fatalf("parseKYCInput failed: %v", err)
+	}
+
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	err = assignKYCTier(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("assignKYCTier failed: %v", err)
+	}
+	if kycRecord.Tier != TierIDVerified {
+		t.Fatalf("tier of a new verified user = %s, want %s", kycRecord.Tier, TierIDVerified)
+	}
+
+	// An existing user keeps their tier whatever the submitted verification status
+	putTestKYCRecord(t, stub, &KYCRecord{UserID: "user123", KYCVerified: false, Tier: TierPhoneVerified})
+	err = assignKYCTier(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("assignKYCTier failed: %v", err)
+	}
+	if kycRecord.Tier != TierPhoneVerified || kycRecord.KYCVerified {
+		t.Fatalf("existing user assigned tier %s, kycVerified %v, want %s, false", kycRecord.Tier, kycRecord.KYCVerified, TierPhoneVerified)
+	}
+}
+
+func TestUpdateKYCStatusRequiresAdmin(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+	address := "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE"
+
+	stub.MockTransactionStart("tx1")
+	err := putPublicKYCRecord(ctx, &PublicKYCRecord{UserID: "user123", SolanaAddress: address, Tier: TierNone})
+	if err != nil {
+		t.Fatalf("putPublicKYCRecord failed: %v", err)
+	}
+
+	for _, identity := range []*testClientIdentity{
+		{mspID: "Org1MSP", ou: []string{"client"}},
+		{mspID: "Org1MSP", attrs: map[string]string{"nivix.bridge": "true"}},
+	} {
+		ctx.SetClientIdentity(identity)
+		err = contract.UpdateKYCStatus(ctx, "user123", address, true, "Documents checked")
+		if err == nil {
+			t.Errorf("UpdateKYCStatus succeeded for non-admin client %+v", identity)
+		}
+	}
+	publicRecord, err := readPublicKYCRecord(ctx, address)
+	if err != nil {
+		t.Fatalf("readPublicKYCRecord failed: %v", err)
+	}
+	if publicRecord.KYCVerified || publicRecord.Tier != TierNone {
+		t.Fatalf("non-admin clients verified the record: kycVerified %v, tier %s", publicRecord.KYCVerified, publicRecord.Tier)
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	err = contract.UpdateKYCStatus(ctx, "user123", address, true, "Documents checked")
+	if err != nil {
+		t.Fatalf("admin: UpdateKYCStatus failed: %v", err)
+	}
+	publicRecord, err = readPublicKYCRecord(ctx, address)
+	if err != nil {
+		t.Fatalf("readPublicKYCRecord failed: %v", err)
+	}
+	if !publicRecord.KYCVerified || publicRecord.Tier != TierIDVerified {
+		t.Fatalf("admin update gave kycVerified %v, tier %s, want true, %s", publicRecord.KYCVerified, publicRecord.Tier, TierIDVerified)
+	}
+}
+
+func TestCheckTierLimits(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	balance := func(value float64) *float64 { return &value }
+	tests := []struct {
+		name    string
+		tier    string
+		data    TransactionValidation
+		limitID string
+	}{
+		{"within limits", TierIDVerified, TransactionValidation{Amount: 500, WalletBalance: balance(1000)}, ""},
+		{"above the transaction limit", TierPhoneVerified, TransactionValidation{Amount: 250, WalletBalance: balance(0)}, "tier-transaction-limit"},
+		{"above the balance limit", TierPhoneVerified, TransactionValidation{Amount: 50, WalletBalance: balance(5000)}, "tier-balance-limit"},
+		{"missing balance", TierIDVerified, TransactionValidation{Amount: 50}, "tier-balance-required"},
+		{"missing balance without a balance limit", TierEnhancedDueDiligence, TransactionValidation{Amount: 50}, ""},
+	}
+	for _, test := range tests {
+		limitID, err := checkTierLimits(ctx, test.tier, &test.data)
+		if err != nil {
+			t.Fatalf("%s: checkTierLimits failed: %v", test.name, err)
+		}
+		if limitID != test.limitID {
+			t.Errorf("%s: checkTierLimits = %q, want %q", test.name, limitID, test.limitID)
+		}
+	}
+}
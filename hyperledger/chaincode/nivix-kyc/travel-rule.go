@@ -0,0 +1,472 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// travelRuleObjectType is the composite key object type of the public Travel Rule status
+const travelRuleObjectType = "travelRule"
+
+// travelRuleThresholdConfig holds the transfer amount from which the Travel Rule applies
+const travelRuleThresholdConfig = "travelRuleThreshold"
+
+// defaultTravelRuleThreshold follows the FATF recommendation of USD/EUR 1000
+const defaultTravelRuleThreshold = 1000
+
+// Travel Rule message statuses
+const (
+	TravelRulePending      = "PENDING"
+	TravelRuleAcknowledged = "ACKNOWLEDGED"
+	TravelRuleRejected     = "REJECTED"
+)
+
+// TravelRuleParty holds the identity information of an originator or beneficiary
+type TravelRuleParty struct {
+	Name           string `json:"name"`
+	AccountAddress string `json:"accountAddress"`
+	CountryCode    string `json:"countryCode,omitempty"`
+	DateOfBirth    string `json:"dateOfBirth,omitempty"`
+	NationalID     string `json:"nationalId,omitempty"`
+}
+
+// TravelRuleMessage is the originator and beneficiary information exchanged between the
+// sending and receiving institutions. It is stored in the implicit org collections of both.
+type TravelRuleMessage struct {
+	TransactionID   string           `json:"transactionId"`
+	OriginatorVASP  string           `json:"originatorVasp"`
+	BeneficiaryVASP string           `json:"beneficiaryVasp"`
+	Originator      *TravelRuleParty `json:"originator"`
+	Beneficiary     *TravelRuleParty `json:"beneficiary"`
+	Amount          float64          `json:"amount"`
+	Currency        string           `json:"currency"`
+}
+
+// TravelRuleStatus is the public, non-identifying state of a Travel Rule message
+type TravelRuleStatus struct {
+	TransactionID   string `json:"transactionId"`
+	OriginatorVASP  string `json:"originatorVasp"`
+	BeneficiaryVASP string `json:"beneficiaryVasp"`
+	Status          string `json:"status"`
+	MessageHash     string `json:"messageHash"`
+	TransferHash    string `json:"transferHash,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	UpdatedAt       string `json:"updatedAt"`
+}
+
+// travelRuleTransfer is the part of a Travel Rule message that must match the transfer
+// passed to RecordTransaction. Its JSON encoding, with the fields in this order, is hashed
+// into the transfer hash of the public status.
+type travelRuleTransfer struct {
+	TransactionID      string `json:"transactionId"`
+	OriginatorAddress  string `json:"originatorAddress"`
+	BeneficiaryAddress string `json:"beneficiaryAddress"`
+	Amount             string `json:"amount"`
+	Currency           string `json:"currency"`
+}
+
+// implicitCollectionName returns the implicit private data collection of an organization
+func implicitCollectionName(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// verifyClientOrgMatchesPeerOrg checks that the client submits the request to a peer of
+// its own organization, so that it cannot read another organization's private data
+func verifyClientOrgMatchesPeerOrg(ctx contractapi.TransactionContextInterface) error {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting the peer's MSPID: %v", err)
+	}
+
+	if clientMSPID != peerMSPID {
+		return fmt.Errorf("client from org %v is not authorized to read or write private data from an org %v peer", clientMSPID, peerMSPID)
+	}
+
+	return nil
+}
+
+// getTravelRuleThreshold returns the configured Travel Rule threshold
+func getTravelRuleThreshold(ctx contractapi.TransactionContextInterface) (float64, error) {
+	threshold := float64(defaultTravelRuleThreshold)
+	_, err := getConfig(ctx, travelRuleThresholdConfig, &threshold)
+	if err != nil {
+		return 0, err
+	}
+
+	return threshold, nil
+}
+
+// travelRuleTransferHash returns the hex SHA-256 hash binding a Travel Rule message to the
+// transaction ID, addresses, amount and currency of its transfer
+func travelRuleTransferHash(transactionID string, originatorAddress string, beneficiaryAddress string, amount float64, currency string) (string, error) {
+	transferJSON, err := json.Marshal(&travelRuleTransfer{
+		TransactionID:      transactionID,
+		OriginatorAddress:  originatorAddress,
+		BeneficiaryAddress: beneficiaryAddress,
+		Amount:             strconv.FormatFloat(amount, 'f', -1, 64),
+		Currency:           currency,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(transferJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// travelRuleKey builds the composite key of a Travel Rule message
+func travelRuleKey(ctx contractapi.TransactionContextInterface, transactionID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(travelRuleObjectType, []string{transactionID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create travel rule key: %v", err)
+	}
+
+	return key, nil
+}
+
+// readTravelRuleStatus reads the public Travel Rule status of a transaction, returning nil if there is none
+func readTravelRuleStatus(ctx contractapi.TransactionContextInterface, transactionID string) (*TravelRuleStatus, error) {
+	key, err := travelRuleKey(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	statusJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read travel rule status: %v", err)
+	}
+	if statusJSON == nil {
+		return nil, nil
+	}
+
+	var status TravelRuleStatus
+	err = json.Unmarshal(statusJSON, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// putTravelRuleStatus writes the public Travel Rule status of a transaction
+func putTravelRuleStatus(ctx contractapi.TransactionContextInterface, status *TravelRuleStatus) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	status.UpdatedAt = now.Format(time.RFC3339)
+
+	key, err := travelRuleKey(ctx, status.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, statusJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put Travel Rule status: %v", err)
+	}
+
+	return emitEvent(ctx, EventTravelRuleStatusChange, &TravelRuleEventPayload{
+		TransactionID:   status.TransactionID,
+		OriginatorVASP:  status.OriginatorVASP,
+		BeneficiaryVASP: status.BeneficiaryVASP,
+		Status:          status.Status,
+	})
+}
+
+// requireTravelRuleAcknowledgement checks that a transfer at or above the Travel Rule
+// threshold has been acknowledged by the beneficiary institution, and that the acknowledged
+// message describes this transfer
+func requireTravelRuleAcknowledgement(ctx contractapi.TransactionContextInterface,
+	transaction *TransactionRecord,
+	amount float64) error {
+
+	threshold, err := getTravelRuleThreshold(ctx)
+	if err != nil {
+		return err
+	}
+	if amount < threshold {
+		return nil
+	}
+
+	transactionID := transaction.TransactionID
+	status, err := readTravelRuleStatus(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return fmt.Errorf("transaction %s requires a travel rule message", transactionID)
+	}
+	if status.Status != TravelRuleAcknowledged {
+		return fmt.Errorf("travel rule message for transaction %s is %s, the beneficiary institution must acknowledge it", transactionID, status.Status)
+	}
+
+	// Messages acknowledged by their own originator, or before transfer hashes existed,
+	// must be submitted again
+	if status.BeneficiaryVASP == status.OriginatorVASP {
+		return fmt.Errorf("travel rule message for transaction %s was not acknowledged by another institution, submit it again", transactionID)
+	}
+	if status.TransferHash == "" {
+		return fmt.Errorf("travel rule message for transaction %s does not record its transfer, submit it again", transactionID)
+	}
+	transferHash, err := travelRuleTransferHash(transactionID, transaction.FromAddress, transaction.ToAddress, amount, transaction.SourceCurrency)
+	if err != nil {
+		return err
+	}
+	if transferHash != status.TransferHash {
+		return fmt.Errorf("travel rule message for transaction %s does not match its addresses, amount or currency", transactionID)
+	}
+
+	return nil
+}
+
+// SubmitTravelRuleMessage shares the originator and beneficiary information of a transfer with
+// the beneficiary institution. The message is read from the "travel_rule" key of the transient
+// map and stored in the implicit org collections of the submitting org and beneficiaryMSPID.
+// Only the bridge service and admins of a bridge MSP may submit messages, and the originator
+// account must be a customer with a KYC record, so that the submitting org is the
+// originating institution.
+func (s *SmartContract) SubmitTravelRuleMessage(ctx contractapi.TransactionContextInterface,
+	transactionId string,
+	beneficiaryMSPID string) error {
+
+	err := assertBridge(ctx)
+	if err != nil {
+		return err
+	}
+	bridgeMSPIDs, err := getBridgeMSPIDs(ctx)
+	if err != nil {
+		return err
+	}
+	bridgeMSP, err := clientMSPIn(ctx, bridgeMSPIDs)
+	if err != nil {
+		return err
+	}
+	if !bridgeMSP {
+		return fmt.Errorf("travel rule messages can only be submitted by an originating institution running the bridge")
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+
+	transientMessageJSON, ok := transientMap["travel_rule"]
+	if !ok {
+		return fmt.Errorf("travel_rule not found in the transient map input")
+	}
+
+	var message TravelRuleMessage
+	err = json.Unmarshal(transientMessageJSON, &message)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	if message.Originator == nil || len(message.Originator.Name) == 0 || len(message.Originator.AccountAddress) == 0 {
+		return fmt.Errorf("originator name and accountAddress must be non-empty strings")
+	}
+	if message.Beneficiary == nil || len(message.Beneficiary.Name) == 0 || len(message.Beneficiary.AccountAddress) == 0 {
+		return fmt.Errorf("beneficiary name and accountAddress must be non-empty strings")
+	}
+	if message.Amount <= 0 {
+		return fmt.Errorf("amount field must be a positive number")
+	}
+	if !currencyCodePattern.MatchString(message.Currency) {
+		return fmt.Errorf("currency field must be an ISO 4217 code")
+	}
+
+	// The originator must be a customer of the originating institution
+	originatorAddress, err := resolveKYCAddress(ctx, message.Originator.AccountAddress)
+	if err != nil {
+		return err
+	}
+	originatorRecord, err := readPublicKYCRecord(ctx, originatorAddress)
+	if err != nil {
+		return err
+	}
+	if originatorRecord == nil || originatorRecord.Erased {
+		return fmt.Errorf("originator account %s has no KYC record", message.Originator.AccountAddress)
+	}
+
+	originatorMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	if len(beneficiaryMSPID) == 0 {
+		return fmt.Errorf("beneficiaryMSPID must be a non-empty string")
+	}
+	if beneficiaryMSPID == originatorMSPID {
+		return fmt.Errorf("beneficiaryMSPID must be another institution than the originator %s", originatorMSPID)
+	}
+
+	// Only the originator of a message may submit it again
+	existing, err := readTravelRuleStatus(ctx, transactionId)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.OriginatorVASP != originatorMSPID {
+		return fmt.Errorf("travel rule message for transaction %s was submitted by %s", transactionId, existing.OriginatorVASP)
+	}
+	if existing != nil && existing.Status != TravelRuleRejected && existing.TransferHash != "" &&
+		existing.BeneficiaryVASP != existing.OriginatorVASP {
+		return fmt.Errorf("travel rule message for transaction %s is already %s", transactionId, existing.Status)
+	}
+
+	message.TransactionID = transactionId
+	message.OriginatorVASP = originatorMSPID
+	message.BeneficiaryVASP = beneficiaryMSPID
+
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	for _, mspID := range []string{originatorMSPID, beneficiaryMSPID} {
+		err = ctx.GetStub().PutPrivateData(implicitCollectionName(mspID), transactionId, messageJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put travel rule message for %s: %v", mspID, err)
+		}
+	}
+
+	messageHash := sha256.Sum256(messageJSON)
+	transferHash, err := travelRuleTransferHash(transactionId, message.Originator.AccountAddress,
+		message.Beneficiary.AccountAddress, message.Amount, message.Currency)
+	if err != nil {
+		return err
+	}
+
+	return putTravelRuleStatus(ctx, &TravelRuleStatus{
+		TransactionID:   transactionId,
+		OriginatorVASP:  originatorMSPID,
+		BeneficiaryVASP: beneficiaryMSPID,
+		Status:          TravelRulePending,
+		MessageHash:     hex.EncodeToString(messageHash[:]),
+		TransferHash:    transferHash,
+	})
+}
+
+// updateTravelRuleStatus moves a pending Travel Rule message to a new status on behalf of the beneficiary institution
+func updateTravelRuleStatus(ctx contractapi.TransactionContextInterface, transactionID string, newStatus string, reason string) error {
+	status, err := readTravelRuleStatus(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return fmt.Errorf("no travel rule message found for transaction %s", transactionID)
+	}
+
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	if clientMSPID != status.BeneficiaryVASP {
+		return fmt.Errorf("only the beneficiary institution %s may respond to this travel rule message", status.BeneficiaryVASP)
+	}
+	if status.Status != TravelRulePending {
+		return fmt.Errorf("travel rule message for transaction %s is already %s", transactionID, status.Status)
+	}
+
+	status.Status = newStatus
+	status.Reason = reason
+
+	return putTravelRuleStatus(ctx, status)
+}
+
+// AcknowledgeTravelRuleMessage confirms receipt of the originator and beneficiary information
+// by the beneficiary institution, allowing the transfer to be recorded
+func (s *SmartContract) AcknowledgeTravelRuleMessage(ctx contractapi.TransactionContextInterface,
+	transactionId string) error {
+
+	return updateTravelRuleStatus(ctx, transactionId, TravelRuleAcknowledged, "")
+}
+
+// RejectTravelRuleMessage refuses a Travel Rule message on behalf of the beneficiary institution.
+// The originator may submit a corrected message.
+func (s *SmartContract) RejectTravelRuleMessage(ctx contractapi.TransactionContextInterface,
+	transactionId string,
+	reason string) error {
+
+	if len(reason) == 0 {
+		return fmt.Errorf("reason must be a non-empty string")
+	}
+
+	return updateTravelRuleStatus(ctx, transactionId, TravelRuleRejected, reason)
+}
+
+// GetTravelRuleStatus returns the public status of the Travel Rule message of a transaction
+func (s *SmartContract) GetTravelRuleStatus(ctx contractapi.TransactionContextInterface,
+	transactionId string) (*TravelRuleStatus, error) {
+
+	status, err := readTravelRuleStatus(ctx, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("no travel rule message found for transaction %s", transactionId)
+	}
+
+	return status, nil
+}
+
+// ReadTravelRuleMessage returns a Travel Rule message from the implicit collection of the client's org
+func (s *SmartContract) ReadTravelRuleMessage(ctx contractapi.TransactionContextInterface,
+	transactionId string) (*TravelRuleMessage, error) {
+
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+
+	messageJSON, err := ctx.GetStub().GetPrivateData(implicitCollectionName(clientMSPID), transactionId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read travel rule message: %v", err)
+	}
+	if messageJSON == nil {
+		return nil, fmt.Errorf("no travel rule message found for transaction %s", transactionId)
+	}
+
+	var message TravelRuleMessage
+	err = json.Unmarshal(messageJSON, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// SetTravelRuleThreshold sets the transfer amount from which a Travel Rule message is required
+func (s *SmartContract) SetTravelRuleThreshold(ctx contractapi.TransactionContextInterface,
+	threshold float64) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if threshold < 0 {
+		return fmt.Errorf("travel rule threshold must not be negative")
+	}
+
+	return putConfig(ctx, travelRuleThresholdConfig, threshold)
+}
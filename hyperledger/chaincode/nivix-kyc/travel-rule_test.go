@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestTravelRuleHandshake(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+	originatorAddress := "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE"
+	beneficiaryAddress := "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"
+	bridgeAttrs := map[string]string{"nivix.bridge": "true"}
+
+	stub.MockTransactionStart("tx1")
+	err := putPublicKYCRecord(ctx, &PublicKYCRecord{UserID: "user123", SolanaAddress: originatorAddress, KYCVerified: true, Tier: TierIDVerified})
+	if err != nil {
+		t.Fatalf("putPublicKYCRecord failed: %v", err)
+	}
+
+	submit := func(identity *testClientIdentity, originator string, beneficiaryMSPID string) error {
+		ctx.SetClientIdentity(identity)
+		err := stub.SetTransient(map[string][]byte{
+			"travel_rule": []byte(`{"originator":{"name":"John Doe","accountAddress":"` + originator + `"},` +
+				`"beneficiary":{"name":"Jane Roe","accountAddress":"` + beneficiaryAddress + `"},"amount":2500,"currency":"USD"}`),
+		})
+		if err != nil {
+			t.Fatalf("failed to set transient map: %v", err)
+		}
+		return contract.SubmitTravelRuleMessage(ctx, "tx123", beneficiaryMSPID)
+	}
+
+	refused := []struct {
+		name             string
+		identity         *testClientIdentity
+		originator       string
+		beneficiaryMSPID string
+	}{
+		{"client without the bridge attribute", &testClientIdentity{mspID: "Org1MSP", ou: []string{"client"}}, originatorAddress, "Org2MSP"},
+		{"bridge attribute of a partner MSP", &testClientIdentity{mspID: "Org3MSP", attrs: bridgeAttrs}, originatorAddress, "Org1MSP"},
+		{"originator without a KYC record", &testClientIdentity{mspID: "Org1MSP", attrs: bridgeAttrs}, beneficiaryAddress, "Org2MSP"},
+		{"originator institution as beneficiary", &testClientIdentity{mspID: "Org1MSP", attrs: bridgeAttrs}, originatorAddress, "Org1MSP"},
+	}
+	for _, test := range refused {
+		if submit(test.identity, test.originator, test.beneficiaryMSPID) == nil {
+			t.Errorf("%s: SubmitTravelRuleMessage succeeded, want an error", test.name)
+		}
+	}
+
+	originatorBridge := &testClientIdentity{mspID: "Org1MSP", attrs: bridgeAttrs}
+	err = submit(originatorBridge, originatorAddress, "Org2MSP")
+	if err != nil {
+		t.Fatalf("SubmitTravelRuleMessage failed: %v", err)
+	}
+	if stub.PvtState[implicitCollectionName("Org2MSP")]["tx123"] == nil {
+		t.Fatal("travel rule message not stored for the beneficiary institution")
+	}
+
+	// The transfer cannot be recorded before the beneficiary institution acknowledges it
+	err = contract.RecordTransaction(ctx, "tx123", originatorAddress, beneficiaryAddress, "2500", "USD", "EUR", "", "")
+	if err == nil {
+		t.Fatal("RecordTransaction succeeded before the acknowledgement")
+	}
+	err = contract.AcknowledgeTravelRuleMessage(ctx, "tx123")
+	if err == nil {
+		t.Fatal("the originating institution acknowledged its own message")
+	}
+	if submit(&testClientIdentity{mspID: "Org2MSP", attrs: bridgeAttrs}, originatorAddress, "Org3MSP") == nil {
+		t.Fatal("another institution resubmitted the message")
+	}
+
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org2MSP", ou: []string{"client"}})
+	err = contract.AcknowledgeTravelRuleMessage(ctx, "tx123")
+	if err != nil {
+		t.Fatalf("AcknowledgeTravelRuleMessage failed: %v", err)
+	}
+
+	// Only the transfer described by the message can be recorded
+	ctx.SetClientIdentity(originatorBridge)
+	err = contract.RecordTransaction(ctx, "tx123", originatorAddress, beneficiaryAddress, "9000", "USD", "EUR", "", "")
+	if err == nil {
+		t.Fatal("RecordTransaction accepted an amount other than the acknowledged one")
+	}
+	err = contract.RecordTransaction(ctx, "tx123", originatorAddress, beneficiaryAddress, "2500", "USD", "EUR", "", "")
+	if err != nil {
+		t.Fatalf("RecordTransaction failed: %v", err)
+	}
+}
+
+func TestReadTravelRuleMessageRequiresOwnPeer(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org2MSP"})
+	contract := new(SmartContract)
+
+	stub.MockTransactionStart("tx1")
+	err := stub.PutPrivateData(implicitCollectionName("Org2MSP"), "tx123", []byte(`{"transactionId":"tx123"}`))
+	if err != nil {
+		t.Fatalf("failed to put travel rule message: %v", err)
+	}
+
+	defer os.Unsetenv("CORE_PEER_LOCALMSPID")
+	os.Setenv("CORE_PEER_LOCALMSPID", "Org1MSP")
+	_, err = contract.ReadTravelRuleMessage(ctx, "tx123")
+	if err == nil {
+		t.Fatal("ReadTravelRuleMessage succeeded on a peer of another org")
+	}
+
+	os.Setenv("CORE_PEER_LOCALMSPID", "Org2MSP")
+	message, err := contract.ReadTravelRuleMessage(ctx, "tx123")
+	if err != nil {
+		t.Fatalf("ReadTravelRuleMessage failed: %v", err)
+	}
+	if message.TransactionID != "tx123" {
+		t.Fatalf("transactionId = %s, want tx123", message.TransactionID)
+	}
+}
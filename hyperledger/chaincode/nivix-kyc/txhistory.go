@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transaction indexes stored under indexObjectType. The sender and recipient indexes are
+// keyed by address and transaction ID, the history index by address, timestamp and
+// transaction ID, so that an address's transactions are listed in date order.
+const (
+	txFromIndex    = "txFrom"
+	txToIndex      = "txTo"
+	txHistoryIndex = "txHistory"
+)
+
+// txHistoryTimestampLayout is a fixed-width UTC layout, so that history index keys sort
+// chronologically
+const txHistoryTimestampLayout = "2006-01-02T15:04:05.000000000Z"
+
+// txHistoryTimestamp returns the history index timestamp of a transaction. Transactions
+// without an RFC 3339 timestamp are indexed under the fallback time.
+func txHistoryTimestamp(timestamp string, fallback time.Time) string {
+	parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		parsed = fallback
+	}
+
+	return parsed.UTC().Format(txHistoryTimestampLayout)
+}
+
+// putTxIndexEntries adds a transaction to the sender, recipient and history indexes. The
+// history entries are keyed by the transaction's HistoryTimestamp.
+func putTxIndexEntries(ctx contractapi.TransactionContextInterface, transaction *TransactionRecord) error {
+	fromKey, err := indexKey(ctx, txFromIndex, transaction.FromAddress, transaction.TransactionID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(fromKey, []byte(transaction.TransactionID))
+	if err != nil {
+		return fmt.Errorf("failed to create from address index: %v", err)
+	}
+
+	toKey, err := indexKey(ctx, txToIndex, transaction.ToAddress, transaction.TransactionID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(toKey, []byte(transaction.TransactionID))
+	if err != nil {
+		return fmt.Errorf("failed to create to address index: %v", err)
+	}
+
+	// A transfer between two addresses appears once in the history of each
+	for _, address := range []string{transaction.FromAddress, transaction.ToAddress} {
+		historyKey, err := indexKey(ctx, txHistoryIndex, address, transaction.HistoryTimestamp, transaction.TransactionID)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(historyKey, []byte(transaction.TransactionID))
+		if err != nil {
+			return fmt.Errorf("failed to create transaction history index: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteTxHistoryEntries removes the history entries of the given transactions from an
+// address's history in one pass, whatever timestamp they were indexed under
+func deleteTxHistoryEntries(ctx contractapi.TransactionContextInterface, address string, transactionIDs map[string]bool) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexObjectType, []string{txHistoryIndex, address})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if !transactionIDs[string(queryResponse.Value)] {
+			continue
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete transaction history index entry: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// GetTransactionHistory retrieves a page of the transactions an address sent or received,
+// oldest first
+func (s *SmartContract) GetTransactionHistory(ctx contractapi.TransactionContextInterface,
+	address string,
+	pageSize int32,
+	bookmark string) (*TransactionQueryResult, error) {
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(indexObjectType, []string{txHistoryIndex, address}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &TransactionQueryResult{
+		Records: []*TransactionRecord{},
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		transaction, err := s.GetTransaction(ctx, string(queryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		result.Records = append(result.Records, transaction)
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+	result.Bookmark = responseMetadata.Bookmark
+
+	return result, nil
+}
+
+// ReindexTransactions rebuilds the sender, recipient and history index entries of recorded
+// transactions. Each call scans up to pageSize transactions and returns a bookmark to pass
+// to the next call until it is empty. Transactions still stored under tx_ keys are only
+// found once MigrateKeyspace has moved them. History entries are keyed by the stored
+// HistoryTimestamp; transactions recorded without one are given one, and those that also
+// lack an RFC 3339 timestamp are listed first in the history. Transactions recorded
+// without the docType and amountValue fields matched by rich queries are given them.
+func (s *SmartContract) ReindexTransactions(ctx contractapi.TransactionContextInterface,
+	pageSize int,
+	bookmark string) (*IndexBackfillResult, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	startKey := ""
+	if bookmark != "" {
+		startKeyBytes, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark: %v", err)
+		}
+		startKey = string(startKeyBytes)
+	}
+
+	entries, nextKey, err := scanObjectType(ctx, txObjectType, startKey, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IndexBackfillResult{
+		Scanned: len(entries),
+	}
+	if nextKey != "" {
+		result.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(nextKey))
+	}
+	transactions := []*TransactionRecord{}
+	updated := []bool{}
+	staleAddresses := []string{}
+	staleTransactionIDs := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		var transaction TransactionRecord
+		if json.Unmarshal(entry.Value, &transaction) != nil || transaction.TransactionID == "" {
+			continue
+		}
+
+		// Transactions recorded before their history timestamp was stored get one. Without
+		// an RFC 3339 timestamp, an earlier entry was indexed under a time that is no longer
+		// known, so it is looked up and removed below.
+		transactionUpdated := false
+		if transaction.HistoryTimestamp == "" {
+			if _, err := time.Parse(time.RFC3339Nano, transaction.Timestamp); err != nil {
+				for _, address := range []string{transaction.FromAddress, transaction.ToAddress} {
+					if staleTransactionIDs[address] == nil {
+						staleTransactionIDs[address] = make(map[string]bool)
+						staleAddresses = append(staleAddresses, address)
+					}
+					staleTransactionIDs[address][transaction.TransactionID] = true
+				}
+			}
+			transaction.HistoryTimestamp = txHistoryTimestamp(transaction.Timestamp, time.Time{})
+			transactionUpdated = true
+		}
+
+		// Transactions recorded before rich queries were supported lack the fields the
+		// queries match on
+		if transaction.DocType == "" {
+			transaction.DocType = docTypeTransaction
+			transactionUpdated = true
+		}
+		if transaction.AmountValue == 0 {
+			amountValue, err := strconv.ParseFloat(transaction.Amount, 64)
+			if err == nil && amountValue > 0 {
+				transaction.AmountValue = amountValue
+				transactionUpdated = true
+			}
+		}
+
+		transactions = append(transactions, &transaction)
+		updated = append(updated, transactionUpdated)
+	}
+
+	// Each address's history is scanned once for all of the page's stale entries, before
+	// the new entries are written
+	for _, address := range staleAddresses {
+		err = deleteTxHistoryEntries(ctx, address, staleTransactionIDs[address])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, transaction := range transactions {
+		if updated[i] {
+			err = putTransaction(ctx, transaction)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = putTxIndexEntries(ctx, transaction)
+		if err != nil {
+			return nil, err
+		}
+		result.Indexed++
+	}
+
+	return result, nil
+}
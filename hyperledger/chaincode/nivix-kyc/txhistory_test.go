@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// historyTestKeys lists the history index entries of an address as their timestamp and
+// transaction ID attributes, in key order
+func historyTestKeys(t *testing.T, stub *levelDBMockStub, address string) []string {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexObjectType, []string{txHistoryIndex, address})
+	if err != nil {
+		t.Fatalf("failed to read history index: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	keys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			t.Fatalf("failed to read history index: %v", err)
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			t.Fatalf("failed to split history key: %v", err)
+		}
+		keys = append(keys, attributes[2]+" "+attributes[3])
+	}
+
+	return keys
+}
+
+func TestReindexTransactionsReplacesStaleHistoryEntries(t *testing.T) {
+	stub := &levelDBMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testClientIdentity{mspID: "Org1MSP", ou: []string{"admin"}})
+	contract := new(SmartContract)
+	stub.MockTransactionStart("tx1")
+
+	// Transactions recorded before the history timestamp was stored. Those without an RFC
+	// 3339 timestamp were listed under the time they were indexed at.
+	legacy := []*TransactionRecord{
+		{TransactionID: "t1", FromAddress: "A", ToAddress: "B", Amount: "5", Timestamp: "2025-06-01T10:00:00Z"},
+		{TransactionID: "t2", FromAddress: "A", ToAddress: "C", Amount: "6", Timestamp: "yesterday"},
+		{TransactionID: "t3", FromAddress: "B", ToAddress: "A", Amount: "7", Timestamp: ""},
+	}
+	indexedAt := time.Date(2025, 6, 2, 8, 0, 0, 0, time.UTC)
+	for _, transaction := range legacy {
+		err := putTransaction(ctx, transaction)
+		if err != nil {
+			t.Fatalf("putTransaction failed: %v", err)
+		}
+		indexed := *transaction
+		indexed.HistoryTimestamp = txHistoryTimestamp(transaction.Timestamp, indexedAt)
+		err = putTxIndexEntries(ctx, &indexed)
+		if err != nil {
+			t.Fatalf("putTxIndexEntries failed: %v", err)
+		}
+	}
+
+	bookmark := ""
+	indexed := 0
+	for calls := 0; ; calls++ {
+		if calls > 10 {
+			t.Fatal("ReindexTransactions did not finish")
+		}
+		result, err := contract.ReindexTransactions(ctx, 2, bookmark)
+		if err != nil {
+			t.Fatalf("ReindexTransactions failed: %v", err)
+		}
+		indexed += result.Indexed
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if indexed != len(legacy) {
+		t.Fatalf("ReindexTransactions indexed %d transactions, want %d", indexed, len(legacy))
+	}
+
+	tests := []struct {
+		address string
+		want    []string
+	}{
+		{"A", []string{"0001-01-01T00:00:00.000000000Z t2", "0001-01-01T00:00:00.000000000Z t3", "2025-06-01T10:00:00.000000000Z t1"}},
+		{"B", []string{"0001-01-01T00:00:00.000000000Z t3", "2025-06-01T10:00:00.000000000Z t1"}},
+		{"C", []string{"0001-01-01T00:00:00.000000000Z t2"}},
+	}
+	for _, test := range tests {
+		keys := historyTestKeys(t, stub, test.address)
+		if len(keys) != len(test.want) {
+			t.Errorf("history of %s = %v, want %v", test.address, keys, test.want)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != test.want[i] {
+				t.Errorf("history of %s = %v, want %v", test.address, keys, test.want)
+				break
+			}
+		}
+	}
+
+	transaction, err := readTransaction(ctx, "t2")
+	if err != nil {
+		t.Fatalf("readTransaction failed: %v", err)
+	}
+	if transaction.HistoryTimestamp != "0001-01-01T00:00:00.000000000Z" || transaction.DocType != docTypeTransaction || transaction.AmountValue != 6 {
+		t.Fatalf("reindexed transaction = %+v, want its history timestamp and query fields set", transaction)
+	}
+}
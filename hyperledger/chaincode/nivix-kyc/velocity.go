@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// spendObjectType is the composite key object type of spend delta rows. Every recorded
+// transaction adds its own row instead of updating a shared counter, so concurrent
+// payments from one address never conflict on the same key.
+const spendObjectType = "spend~address~currency~timestamp~amount~txID"
+
+// velocityLimitsConfig holds the cumulative spend limits enforced by ValidateTransaction
+const velocityLimitsConfig = "velocityLimits"
+
+// Rolling velocity windows
+const (
+	dailyWindow   = 24 * time.Hour
+	weeklyWindow  = 7 * 24 * time.Hour
+	monthlyWindow = 30 * 24 * time.Hour
+)
+
+// VelocityLimit caps the cumulative amount an address may send in a currency over rolling
+// windows when its risk score lies within [MinRiskScore, MaxRiskScore]. A limit with an
+// empty Currency caps the spend in all currencies together, added at face value, and a
+// zero limit leaves that window unlimited.
+type VelocityLimit struct {
+	ID           string  `json:"id"`
+	Currency     string  `json:"currency,omitempty"`
+	MinRiskScore int     `json:"minRiskScore"`
+	MaxRiskScore int     `json:"maxRiskScore"`
+	Daily        float64 `json:"daily"`
+	Weekly       float64 `json:"weekly"`
+	Monthly      float64 `json:"monthly"`
+}
+
+// SpendTotals holds the cumulative amount sent by an address in a currency, or in all
+// currencies when Currency is empty, over each rolling window
+type SpendTotals struct {
+	Address  string  `json:"address"`
+	Currency string  `json:"currency"`
+	Daily    float64 `json:"daily"`
+	Weekly   float64 `json:"weekly"`
+	Monthly  float64 `json:"monthly"`
+}
+
+// getVelocityLimits reads the configured velocity limits
+func getVelocityLimits(ctx contractapi.TransactionContextInterface) ([]*VelocityLimit, error) {
+	limits := []*VelocityLimit{}
+	_, err := getConfig(ctx, velocityLimitsConfig, &limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+// addSpendDelta appends a spend delta row for a transaction without reading existing rows.
+// The row counts from spentAt, so a release written with the time of the spend it offsets
+// leaves the rolling windows together with it.
+func addSpendDelta(ctx contractapi.TransactionContextInterface, address string, currency string, amount float64, spentAt time.Time) error {
+	spendKey, err := ctx.GetStub().CreateCompositeKey(spendObjectType, []string{
+		address,
+		currency,
+		spentAt.Format(keyTimeLayout),
+		strconv.FormatFloat(amount, 'f', -1, 64),
+		ctx.GetStub().GetTxID(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create spend key: %v", err)
+	}
+
+	return ctx.GetStub().PutState(spendKey, []byte{0x00})
+}
+
+// getSpendTotals aggregates the spend delta rows of an address in a currency over the
+// rolling windows. An empty currency aggregates the rows of every currency.
+func getSpendTotals(ctx contractapi.TransactionContextInterface, address string, currency string) (*SpendTotals, error) {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyAttributes := []string{address}
+	if currency != "" {
+		keyAttributes = append(keyAttributes, currency)
+	}
+	deltaResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(spendObjectType, keyAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spend counters for %s: %v", address, err)
+	}
+	defer deltaResultsIterator.Close()
+
+	totals := &SpendTotals{
+		Address:  address,
+		Currency: currency,
+	}
+	for deltaResultsIterator.HasNext() {
+		responseRange, err := deltaResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		spendTime, err := time.Parse(keyTimeLayout, keyParts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in spend key: %v", err)
+		}
+		amount, err := strconv.ParseFloat(keyParts[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount in spend key: %v", err)
+		}
+
+		age := now.Sub(spendTime)
+		if age < monthlyWindow {
+			totals.Monthly += amount
+		}
+		if age < weeklyWindow {
+			totals.Weekly += amount
+		}
+		if age < dailyWindow {
+			totals.Daily += amount
+		}
+	}
+
+	return totals, nil
+}
+
+// getUserSpendTotals aggregates the spend of every address linked to the same user as an
+// address, so that spreading payments over several wallets does not evade the limits
+func getUserSpendTotals(ctx contractapi.TransactionContextInterface, address string, currency string) (*SpendTotals, error) {
+	addresses, err := linkedAddresses(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	userTotals := &SpendTotals{
+		Address:  address,
+		Currency: currency,
+	}
+	for _, linkedAddress := range addresses {
+		totals, err := getSpendTotals(ctx, linkedAddress, currency)
+		if err != nil {
+			return nil, err
+		}
+		userTotals.Daily += totals.Daily
+		userTotals.Weekly += totals.Weekly
+		userTotals.Monthly += totals.Monthly
+	}
+
+	return userTotals, nil
+}
+
+// checkVelocityLimits returns the limit and window the transaction would exceed, or nil when within all limits
+func checkVelocityLimits(ctx contractapi.TransactionContextInterface, address string, riskScore int, transactionData *TransactionValidation) (*VelocityLimit, string, error) {
+	limits, err := getVelocityLimits(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Limits without a currency are checked against the spend in all currencies
+	totalsByCurrency := make(map[string]*SpendTotals)
+	for _, limit := range limits {
+		if riskScore < limit.MinRiskScore || riskScore > limit.MaxRiskScore {
+			continue
+		}
+		if limit.Currency != "" && limit.Currency != transactionData.Currency {
+			continue
+		}
+
+		totals, ok := totalsByCurrency[limit.Currency]
+		if !ok {
+			totals, err = getUserSpendTotals(ctx, address, limit.Currency)
+			if err != nil {
+				return nil, "", err
+			}
+			totalsByCurrency[limit.Currency] = totals
+		}
+
+		if limit.Daily > 0 && totals.Daily+transactionData.Amount > limit.Daily {
+			return limit, "daily", nil
+		}
+		if limit.Weekly > 0 && totals.Weekly+transactionData.Amount > limit.Weekly {
+			return limit, "weekly", nil
+		}
+		if limit.Monthly > 0 && totals.Monthly+transactionData.Amount > limit.Monthly {
+			return limit, "monthly", nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// SetVelocityLimits replaces the cumulative spend limits enforced by ValidateTransaction
+func (s *SmartContract) SetVelocityLimits(ctx contractapi.TransactionContextInterface,
+	limitsJSON string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var limits []*VelocityLimit
+	err = json.Unmarshal([]byte(limitsJSON), &limits)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal velocity limits JSON: %v", err)
+	}
+
+	limitIDs := make(map[string]bool)
+	for _, limit := range limits {
+		if limit == nil || len(limit.ID) == 0 {
+			return fmt.Errorf("every velocity limit must have a non-empty id")
+		}
+		if limitIDs[limit.ID] {
+			return fmt.Errorf("duplicate velocity limit id %s", limit.ID)
+		}
+		limitIDs[limit.ID] = true
+
+		if limit.MinRiskScore < 0 || limit.MaxRiskScore > 100 || limit.MinRiskScore > limit.MaxRiskScore {
+			return fmt.Errorf("velocity limit %s must have a risk band within 0 and 100", limit.ID)
+		}
+		if limit.Currency != "" && !currencyCodePattern.MatchString(limit.Currency) {
+			return fmt.Errorf("velocity limit %s currency must be an ISO 4217 code", limit.ID)
+		}
+		if limit.Daily < 0 || limit.Weekly < 0 || limit.Monthly < 0 {
+			return fmt.Errorf("velocity limit %s must not have negative limits", limit.ID)
+		}
+	}
+
+	return putConfig(ctx, velocityLimitsConfig, limits)
+}
+
+// GetVelocityLimits returns the cumulative spend limits enforced by ValidateTransaction
+func (s *SmartContract) GetVelocityLimits(ctx contractapi.TransactionContextInterface) ([]*VelocityLimit, error) {
+	return getVelocityLimits(ctx)
+}
+
+// GetSpendTotals returns the cumulative amount sent by an address in a currency over the
+// rolling windows. An empty currency returns the spend in all currencies together.
+func (s *SmartContract) GetSpendTotals(ctx contractapi.TransactionContextInterface,
+	address string,
+	currency string) (*SpendTotals, error) {
+
+	return getSpendTotals(ctx, address, currency)
+}
+
+// PruneSpendCounters deletes the spend delta rows of an address that have left every
+// rolling window. Run it in quiet periods, as it conflicts with concurrent payments
+// from the same address.
+func (s *SmartContract) PruneSpendCounters(ctx contractapi.TransactionContextInterface,
+	address string) (int, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deltaResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(spendObjectType, []string{address})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read spend counters for %s: %v", address, err)
+	}
+	defer deltaResultsIterator.Close()
+
+	pruned := 0
+	for deltaResultsIterator.HasNext() {
+		responseRange, err := deltaResultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		spendTime, err := time.Parse(keyTimeLayout, keyParts[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp in spend key: %v", err)
+		}
+		if now.Sub(spendTime) < monthlyWindow {
+			continue
+		}
+
+		err = ctx.GetStub().DelState(responseRange.Key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete spend row: %v", err)
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
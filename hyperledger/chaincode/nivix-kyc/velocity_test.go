@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// spendTestRow is a spend delta row recorded age before the test transaction
+type spendTestRow struct {
+	currency string
+	amount   float64
+	age      time.Duration
+}
+
+// newVelocityTestContext starts a transaction with spend rows of one address
+func newVelocityTestContext(t *testing.T, rows []spendTestRow) *TransactionContext {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	stub.MockTransactionStart("tx1")
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		t.Fatalf("txTimestamp failed: %v", err)
+	}
+	for _, row := range rows {
+		err = addSpendDelta(ctx, "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE", row.currency, row.amount, now.Add(-row.age))
+		if err != nil {
+			t.Fatalf("addSpendDelta failed: %v", err)
+		}
+	}
+
+	return ctx
+}
+
+var velocityTestRows = []spendTestRow{
+	{"USD", 100, time.Hour},
+	{"USD", 200, 3 * 24 * time.Hour},
+	{"USD", 400, 20 * 24 * time.Hour},
+	{"USD", 800, 40 * 24 * time.Hour},
+	{"EUR", 50, time.Hour},
+	// A failed transfer released with a negative row dated at the original payment
+	{"USD", 30, 2 * time.Hour},
+	{"USD", -30, 2 * time.Hour},
+}
+
+func TestGetSpendTotalsRollingWindows(t *testing.T) {
+	ctx := newVelocityTestContext(t, velocityTestRows)
+
+	tests := []struct {
+		currency string
+		daily    float64
+		weekly   float64
+		monthly  float64
+	}{
+		{"USD", 100, 300, 700},
+		{"EUR", 50, 50, 50},
+		{"GBP", 0, 0, 0},
+		{"", 150, 350, 750},
+	}
+	for _, test := range tests {
+		totals, err := getSpendTotals(ctx, "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE", test.currency)
+		if err != nil {
+			t.Fatalf("getSpendTotals(%q) failed: %v", test.currency, err)
+		}
+		if totals.Daily != test.daily || totals.Weekly != test.weekly || totals.Monthly != test.monthly {
+			t.Errorf("getSpendTotals(%q) = %v/%v/%v, want %v/%v/%v", test.currency,
+				totals.Daily, totals.Weekly, totals.Monthly, test.daily, test.weekly, test.monthly)
+		}
+	}
+}
+
+func TestCheckVelocityLimits(t *testing.T) {
+	ctx := newVelocityTestContext(t, velocityTestRows)
+
+	tests := []struct {
+		name     string
+		limits   []*VelocityLimit
+		currency string
+		amount   float64
+		limitID  string
+		window   string
+	}{
+		{"within the daily limit", []*VelocityLimit{{ID: "usd", Currency: "USD", MaxRiskScore: 100, Daily: 200}}, "USD", 100, "", ""},
+		{"over the daily limit", []*VelocityLimit{{ID: "usd", Currency: "USD", MaxRiskScore: 100, Daily: 200}}, "USD", 101, "usd", "daily"},
+		{"over the weekly limit", []*VelocityLimit{{ID: "usd", Currency: "USD", MaxRiskScore: 100, Weekly: 350}}, "USD", 60, "usd", "weekly"},
+		{"over the monthly limit", []*VelocityLimit{{ID: "usd", Currency: "USD", MaxRiskScore: 100, Monthly: 750}}, "USD", 60, "usd", "monthly"},
+		{"limit of another currency", []*VelocityLimit{{ID: "usd", Currency: "USD", MaxRiskScore: 100, Daily: 100}}, "EUR", 60, "", ""},
+		{"limit outside the risk band", []*VelocityLimit{{ID: "high-risk", MinRiskScore: 71, MaxRiskScore: 100, Daily: 1}}, "USD", 60, "", ""},
+		{"limit of all currencies", []*VelocityLimit{{ID: "all", MaxRiskScore: 100, Daily: 200}}, "EUR", 60, "all", "daily"},
+	}
+	for _, test := range tests {
+		err := putConfig(ctx, velocityLimitsConfig, test.limits)
+		if err != nil {
+			t.Fatalf("putConfig failed: %v", err)
+		}
+		limit, window, err := checkVelocityLimits(ctx, "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE", 30,
+			&TransactionValidation{Amount: test.amount, Currency: test.currency})
+		if err != nil {
+			t.Fatalf("%s: checkVelocityLimits failed: %v", test.name, err)
+		}
+		limitID := ""
+		if limit != nil {
+			limitID = limit.ID
+		}
+		if limitID != test.limitID || window != test.window {
+			t.Errorf("%s: checkVelocityLimits = %q, %q, want %q, %q", test.name, limitID, window, test.limitID, test.window)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// kycDigestObjectType is the composite key object type of the plaintext digests of KYC records
+const kycDigestObjectType = "kycDigest~user"
+
+// Results of a KYC record hash verification
+const (
+	HashMatch    = "MATCH"
+	HashMismatch = "MISMATCH"
+)
+
+// KYCHashVerification is the result of comparing a candidate KYC record with the hash of
+// the record held in the kycPrivateData collection
+type KYCHashVerification struct {
+	UserID        string `json:"userId"`
+	Result        string `json:"result"`
+	Match         bool   `json:"match"`
+	CandidateHash string `json:"candidateHash"`
+}
+
+// kycDigestKey builds the composite key of the plaintext digest of a user's KYC record
+func kycDigestKey(ctx contractapi.TransactionContextInterface, userId string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(kycDigestObjectType, []string{userId})
+	if err != nil {
+		return "", fmt.Errorf("failed to create KYC digest key: %v", err)
+	}
+
+	return key, nil
+}
+
+// putKYCRecordDigest stores the hex SHA-256 digest of a KYC record's plaintext JSON next to
+// the record. The record itself may be encrypted, but the channel ledger holds the hash of
+// the digest, against which VerifyKYCRecordHash checks a candidate record.
+func putKYCRecordDigest(ctx contractapi.TransactionContextInterface, storedRecord *KYCRecord) error {
+	recordJSON, err := json.Marshal(storedRecord)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(recordJSON)
+
+	key, err := kycDigestKey(ctx, storedRecord.UserID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData("kycPrivateData", key, []byte(hex.EncodeToString(digest[:])))
+	if err != nil {
+		return fmt.Errorf("failed to put KYC digest: %v", err)
+	}
+
+	return nil
+}
+
+// kycRecordHashes returns the hashes a stored copy of the candidate record may have.
+// Records are stored without their derived expiry fields, and records stored before
+// tiers existed have no tier, which is then reported as their effective tier.
+func kycRecordHashes(candidate *KYCRecord) ([][]byte, error) {
+	storedRecord := *candidate
+	storedRecord.ExpiryDate = ""
+	storedRecord.ExpiryStatus = ""
+
+	variants := []KYCRecord{storedRecord}
+	legacyRecord := storedRecord
+	legacyRecord.Tier = ""
+	if storedRecord.Tier != "" && storedRecord.Tier == effectiveTier(&legacyRecord) {
+		variants = append(variants, legacyRecord)
+	}
+
+	hashes := make([][]byte, 0, len(variants))
+	for i := range variants {
+		recordJSON, err := json.Marshal(&variants[i])
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(recordJSON)
+		hashes = append(hashes, hash[:])
+	}
+
+	return hashes, nil
+}
+
+// VerifyKYCRecordHash checks whether a KYC record shown by a customer matches the record
+// Nivix holds, using only the private data hash on the channel ledger. Orgs outside the
+// kycPrivateData collection can therefore confirm a record without being able to read it.
+// candidateRecordJSON is the record as returned by GetKYCStatus to a collection member.
+// The candidate is checked against the hash of the record's plaintext digest, which works
+// for encrypted records too. Records written before digests existed are checked against
+// the hash of the record itself, which is only possible when it is not encrypted.
+func (s *SmartContract) VerifyKYCRecordHash(ctx contractapi.TransactionContextInterface,
+	userId string,
+	candidateRecordJSON string) (*KYCHashVerification, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(candidateRecordJSON)))
+	decoder.DisallowUnknownFields()
+
+	var candidate KYCRecord
+	err := decoder.Decode(&candidate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal KYC JSON: %v", err)
+	}
+	if candidate.UserID != userId {
+		return nil, fmt.Errorf("candidate record belongs to user %s, not %s", candidate.UserID, userId)
+	}
+
+	recordHash, err := ctx.GetStub().GetPrivateDataHash("kycPrivateData", userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC data hash: %v", err)
+	}
+	if recordHash == nil {
+		return nil, fmt.Errorf("no KYC record found for user %s", userId)
+	}
+
+	candidateHashes, err := kycRecordHashes(&candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	digestKey, err := kycDigestKey(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	digestHash, err := ctx.GetStub().GetPrivateDataHash("kycPrivateData", digestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KYC digest hash: %v", err)
+	}
+	expectedHash := digestHash
+	if digestHash == nil {
+		expectedHash = recordHash
+		key, err := dataKeyKey(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		for _, collection := range []string{dataKeyCollection, legacyDataKeyCollection} {
+			dataKeyHash, err := ctx.GetStub().GetPrivateDataHash(collection, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read data key hash: %v", err)
+			}
+			if dataKeyHash != nil {
+				return nil, fmt.Errorf("KYC record of user %s is encrypted and has no digest yet, it can be verified by hash after its next update", userId)
+			}
+		}
+	}
+
+	verification := &KYCHashVerification{
+		UserID:        userId,
+		Result:        HashMismatch,
+		CandidateHash: hex.EncodeToString(candidateHashes[0]),
+	}
+	for _, candidateHash := range candidateHashes {
+		ledgerHash := candidateHash
+		if digestHash != nil {
+			// The digest is stored as hex, so the ledger holds the hash of the hex candidate hash
+			hash := sha256.Sum256([]byte(hex.EncodeToString(candidateHash)))
+			ledgerHash = hash[:]
+		}
+		if bytes.Equal(ledgerHash, expectedHash) {
+			verification.Result = HashMatch
+			verification.Match = true
+			verification.CandidateHash = hex.EncodeToString(candidateHash)
+			break
+		}
+	}
+
+	return verification, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestVerifyKYCRecordHashOfEncryptedRecord(t *testing.T) {
+	stub := &purgingMockStub{shimtest.NewMockStub("nivix-kyc", nil)}
+	orgKey := []byte(strings.Repeat("o", 32))
+	kycRecord := &KYCRecord{
+		UserID:           "user123",
+		SolanaAddress:    "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		FullName:         "John Doe",
+		KYCVerified:      true,
+		VerificationDate: "2025-05-17T12:00:00Z",
+		RiskScore:        25,
+		CountryCode:      "US",
+		Tier:             TierAddressVerified,
+	}
+
+	ctx := newEncryptionTestContext(t, stub, "tx1", map[string][]byte{orgKeyTransientField: orgKey, dataKeyTransientField: []byte(strings.Repeat("d", 32))})
+	encrypted, err := encodeKYCRecord(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("encodeKYCRecord failed: %v", err)
+	}
+	err = stub.PutPrivateData("kycPrivateData", kycRecord.UserID, encrypted)
+	if err != nil {
+		t.Fatalf("failed to put KYC record: %v", err)
+	}
+	err = putKYCRecordDigest(ctx, kycRecord)
+	if err != nil {
+		t.Fatalf("putKYCRecordDigest failed: %v", err)
+	}
+
+	// An org outside the collection holds neither the org key nor the record
+	ctx = newEncryptionTestContext(t, stub, "tx2", nil)
+	contract := new(SmartContract)
+
+	candidate := *kycRecord
+	candidate.ExpiryDate = "2028-05-16"
+	candidate.ExpiryStatus = ExpiryValid
+	candidateJSON, _ := json.Marshal(&candidate)
+	verification, err := contract.VerifyKYCRecordHash(ctx, "user123", string(candidateJSON))
+	if err != nil {
+		t.Fatalf("VerifyKYCRecordHash failed: %v", err)
+	}
+	if verification.Result != HashMatch {
+		t.Fatalf("verification result = %s, want %s", verification.Result, HashMatch)
+	}
+
+	candidate.RiskScore = 10
+	candidateJSON, _ = json.Marshal(&candidate)
+	verification, err = contract.VerifyKYCRecordHash(ctx, "user123", string(candidateJSON))
+	if err != nil {
+		t.Fatalf("VerifyKYCRecordHash failed: %v", err)
+	}
+	if verification.Result != HashMismatch {
+		t.Fatalf("verification result of a changed record = %s, want %s", verification.Result, HashMismatch)
+	}
+}
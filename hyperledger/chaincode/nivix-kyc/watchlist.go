@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// watchlistObjectType is the composite key object type of sanctions and watchlist entries
+const watchlistObjectType = "watchlist"
+
+// screeningThresholdConfig holds the minimum similarity score reported as a match
+const screeningThresholdConfig = "screeningThreshold"
+
+// defaultScreeningThreshold applies until an admin configures a threshold
+const defaultScreeningThreshold = 85
+
+// Screening statuses of a KYC record
+const (
+	ScreeningHeld    = "HELD"
+	ScreeningCleared = "CLEARED"
+	ScreeningBlocked = "BLOCKED"
+)
+
+// WatchlistEntry is a sanctioned or watched person imported from a list source
+type WatchlistEntry struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases,omitempty"`
+	DateOfBirth string   `json:"dateOfBirth,omitempty"`
+	Country     string   `json:"country,omitempty"`
+	ListSource  string   `json:"listSource"`
+}
+
+// ScreeningMatch describes a watchlist entry matched by a screened name
+type ScreeningMatch struct {
+	EntryID     string `json:"entryId"`
+	ListSource  string `json:"listSource"`
+	MatchedName string `json:"matchedName"`
+	Score       int    `json:"score"`
+}
+
+// accentReplacer folds common Latin accented letters to their base letter
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// normalizeName lowercases a name, folds accents, drops punctuation and sorts
+// its tokens so that word order does not affect matching
+func normalizeName(name string) string {
+	name = accentReplacer.Replace(strings.ToLower(name))
+	tokens := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(tokens)
+
+	return strings.Join(tokens, " ")
+}
+
+// nameSimilarity scores two normalized names from 0 to 100 by their Levenshtein distance
+func nameSimilarity(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return 100 - previous[len(rb)]*100/longest
+}
+
+// minInt returns the smaller of two integers
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// getScreeningThreshold returns the configured screening threshold
+func getScreeningThreshold(ctx contractapi.TransactionContextInterface) (int, error) {
+	threshold := defaultScreeningThreshold
+	_, err := getConfig(ctx, screeningThresholdConfig, &threshold)
+	if err != nil {
+		return 0, err
+	}
+
+	return threshold, nil
+}
+
+// screenName compares a name against every watchlist entry and returns the entries
+// scoring at or above the configured threshold. Entries with a date of birth only
+// match subjects with the same date of birth, when the subject's date is known.
+func screenName(ctx contractapi.TransactionContextInterface, name string, dateOfBirth string) ([]*ScreeningMatch, error) {
+	matches := []*ScreeningMatch{}
+
+	normalized := normalizeName(name)
+	if normalized == "" {
+		return matches, nil
+	}
+
+	threshold, err := getScreeningThreshold(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(watchlistObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watchlist: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry WatchlistEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.DateOfBirth != "" && dateOfBirth != "" && entry.DateOfBirth != dateOfBirth {
+			continue
+		}
+
+		best := &ScreeningMatch{
+			EntryID:    entry.ID,
+			ListSource: entry.ListSource,
+		}
+		for _, candidate := range append([]string{entry.Name}, entry.Aliases...) {
+			score := nameSimilarity(normalized, normalizeName(candidate))
+			if score > best.Score {
+				best.Score = score
+				best.MatchedName = candidate
+			}
+		}
+
+		if best.Score >= threshold {
+			matches = append(matches, best)
+		}
+	}
+
+	return matches, nil
+}
+
+// describeMatches summarises screening matches for compliance events
+func describeMatches(matches []*ScreeningMatch) string {
+	descriptions := make([]string, 0, len(matches))
+	for _, match := range matches {
+		descriptions = append(descriptions, fmt.Sprintf("%s/%s (score %d)", match.ListSource, match.EntryID, match.Score))
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+// screenKYCRecord screens a customer before their KYC record is stored. A match puts the
+// record on hold and records a compliance event. A record already held or blocked keeps
+// its status whatever the new data, so that resubmitting a held or blocked customer with
+// different details cannot release them; only ResolveScreeningHold changes it. A cleared
+// record stays cleared only while its name, date of birth and watchlist matches are the
+// ones the compliance officer cleared.
+func (s *SmartContract) screenKYCRecord(ctx contractapi.TransactionContextInterface, kycRecord *KYCRecord) error {
+	existingStatus, err := getScreeningStatus(ctx, kycRecord.UserID, kycRecord.SolanaAddress)
+	if err != nil {
+		return err
+	}
+	kycRecord.ScreeningStatus = existingStatus
+	kycRecord.ClearedMatches = nil
+
+	// A blocked customer stays unverified
+	if existingStatus == ScreeningBlocked {
+		kycRecord.KYCVerified = false
+		kycRecord.Tier = TierNone
+		return nil
+	}
+
+	matches, err := screenName(ctx, kycRecord.FullName, kycRecord.DateOfBirth)
+	if err != nil {
+		return err
+	}
+
+	if existingStatus == ScreeningCleared {
+		existing, err := getPrivateKYCRecord(ctx, kycRecord.UserID)
+		if err == nil && existing != nil && clearanceApplies(existing, kycRecord, matches) {
+			kycRecord.ClearedMatches = existing.ClearedMatches
+			return nil
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	kycRecord.ScreeningStatus = ScreeningHeld
+
+	return s.RecordComplianceEvent(ctx, kycRecord.UserID, "Sanctions Screening Match",
+		fmt.Sprintf("KYC record held on watchlist match: %s", describeMatches(matches)))
+}
+
+// clearanceApplies reports whether the clearance of a stored record covers a resubmitted
+// one: the screened name and date of birth are unchanged and every watchlist match was
+// among those cleared
+func clearanceApplies(existing *KYCRecord, kycRecord *KYCRecord, matches []*ScreeningMatch) bool {
+	if normalizeName(existing.FullName) != normalizeName(kycRecord.FullName) || existing.DateOfBirth != kycRecord.DateOfBirth {
+		return false
+	}
+
+	cleared := make(map[string]bool, len(existing.ClearedMatches))
+	for _, matchID := range existing.ClearedMatches {
+		cleared[matchID] = true
+	}
+	for _, match := range matches {
+		if !cleared[screeningMatchID(match)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// screeningMatchID identifies the watchlist entry of a match
+func screeningMatchID(match *ScreeningMatch) string {
+	return match.ListSource + "/" + match.EntryID
+}
+
+// getScreeningStatus returns the screening status of the KYC record already stored for a
+// user, falling back to the public reference under their primary address when the private
+// record cannot be read
+func getScreeningStatus(ctx contractapi.TransactionContextInterface, userId string, solanaAddress string) (string, error) {
+	existing, err := getPrivateKYCRecord(ctx, userId)
+	if err == nil && existing != nil {
+		return existing.ScreeningStatus, nil
+	}
+
+	links, err := getAddressLinks(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	for _, link := range links {
+		if link.Primary {
+			solanaAddress = link.Address
+		}
+	}
+
+	publicRecord, err := readPublicKYCRecord(ctx, solanaAddress)
+	if err != nil {
+		return "", err
+	}
+	if publicRecord == nil || publicRecord.UserID != userId {
+		return "", nil
+	}
+
+	return publicRecord.ScreeningStatus, nil
+}
+
+// ImportWatchlistEntries adds or replaces watchlist entries in bulk
+func (s *SmartContract) ImportWatchlistEntries(ctx contractapi.TransactionContextInterface,
+	entriesJSON string) (int, error) {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []*WatchlistEntry
+	err = json.Unmarshal([]byte(entriesJSON), &entries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal watchlist JSON: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry == nil || len(entry.ID) == 0 {
+			return 0, fmt.Errorf("every watchlist entry must have a non-empty id")
+		}
+		if len(entry.ListSource) == 0 {
+			return 0, fmt.Errorf("watchlist entry %s must have a listSource", entry.ID)
+		}
+		if normalizeName(entry.Name) == "" {
+			return 0, fmt.Errorf("watchlist entry %s must have a name", entry.ID)
+		}
+		if entry.Country != "" && !countryCodePattern.MatchString(entry.Country) {
+			return 0, fmt.Errorf("watchlist entry %s country must be an ISO 3166-1 alpha-2 code", entry.ID)
+		}
+
+		entryKey, err := ctx.GetStub().CreateCompositeKey(watchlistObjectType, []string{entry.ListSource, entry.ID})
+		if err != nil {
+			return 0, fmt.Errorf("failed to create watchlist key: %v", err)
+		}
+
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().PutState(entryKey, entryJSON)
+		if err != nil {
+			return 0, fmt.Errorf("failed to put watchlist entry %s: %v", entry.ID, err)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// RemoveWatchlistEntry deletes a watchlist entry
+func (s *SmartContract) RemoveWatchlistEntry(ctx contractapi.TransactionContextInterface,
+	listSource string,
+	entryId string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(watchlistObjectType, []string{listSource, entryId})
+	if err != nil {
+		return fmt.Errorf("failed to create watchlist key: %v", err)
+	}
+
+	return ctx.GetStub().DelState(entryKey)
+}
+
+// SetScreeningThreshold sets the minimum similarity score, from 1 to 100, reported as a watchlist match
+func (s *SmartContract) SetScreeningThreshold(ctx contractapi.TransactionContextInterface,
+	threshold int) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if threshold < 1 || threshold > 100 {
+		return fmt.Errorf("screening threshold must be between 1 and 100")
+	}
+
+	return putConfig(ctx, screeningThresholdConfig, threshold)
+}
+
+// ScreenName returns the watchlist entries matching a name and optional date of birth
+func (s *SmartContract) ScreenName(ctx contractapi.TransactionContextInterface,
+	name string,
+	dateOfBirth string) ([]*ScreeningMatch, error) {
+
+	return screenName(ctx, name, dateOfBirth)
+}
+
+// ResolveScreeningHold records a compliance officer's decision on a held KYC record.
+// A cleared record is released, a confirmed match blocks the record and revokes its verification.
+func (s *SmartContract) ResolveScreeningHold(ctx contractapi.TransactionContextInterface,
+	userId string,
+	confirmedMatch bool,
+	reason string) error {
+
+	err := assertAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	kycRecord, err := readPrivateKYCRecord(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if kycRecord.ScreeningStatus != ScreeningHeld {
+		return fmt.Errorf("KYC record of user %s is not held for screening", userId)
+	}
+
+	kycRecord.ScreeningStatus = ScreeningCleared
+	kycRecord.ClearedMatches = nil
+	if confirmedMatch {
+		kycRecord.ScreeningStatus = ScreeningBlocked
+		kycRecord.KYCVerified = false
+		kycRecord.Tier = TierNone
+	} else {
+		// The clearance covers the current matches of the record as screened
+		matches, err := screenName(ctx, kycRecord.FullName, kycRecord.DateOfBirth)
+		if err != nil {
+			return err
+		}
+		kycRecord.ClearedMatches = []string{}
+		for _, match := range matches {
+			kycRecord.ClearedMatches = append(kycRecord.ClearedMatches, screeningMatchID(match))
+		}
+	}
+
+	err = s.putKYCRecord(ctx, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	err = emitKYCEvent(ctx, EventKYCStatusChanged, kycRecord)
+	if err != nil {
+		return err
+	}
+
+	return s.RecordComplianceEvent(ctx, userId, "Sanctions Screening Resolution",
+		fmt.Sprintf("Screening status set to %s: %s", kycRecord.ScreeningStatus, reason))
+}
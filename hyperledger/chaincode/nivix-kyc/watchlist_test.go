@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// putTestWatchlistEntry writes a watchlist entry directly to the world state
+func putTestWatchlistEntry(t *testing.T, stub *shimtest.MockStub, entry *WatchlistEntry) {
+	entryKey, err := stub.CreateCompositeKey(watchlistObjectType, []string{entry.ListSource, entry.ID})
+	if err != nil {
+		t.Fatalf("failed to create watchlist key: %v", err)
+	}
+	entryJSON, _ := json.Marshal(entry)
+	err = stub.PutState(entryKey, entryJSON)
+	if err != nil {
+		t.Fatalf("failed to put watchlist entry: %v", err)
+	}
+}
+
+// putTestKYCRecord writes an unencrypted KYC record directly to the private collection
+func putTestKYCRecord(t *testing.T, stub *shimtest.MockStub, kycRecord *KYCRecord) {
+	kycJSON, _ := json.Marshal(kycRecord)
+	err := stub.PutPrivateData("kycPrivateData", kycRecord.UserID, kycJSON)
+	if err != nil {
+		t.Fatalf("failed to put KYC record: %v", err)
+	}
+}
+
+func TestScreenKYCRecordRescreensClearedRecords(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+
+	stub.MockTransactionStart("tx1")
+	putTestWatchlistEntry(t, stub, &WatchlistEntry{ID: "sdn-1", Name: "Ivan Petrov", ListSource: "OFAC"})
+	putTestKYCRecord(t, stub, &KYCRecord{
+		UserID:          "user123",
+		SolanaAddress:   "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		FullName:        "John Doe",
+		DateOfBirth:     "1980-01-01",
+		ScreeningStatus: ScreeningCleared,
+		ClearedMatches:  []string{},
+	})
+
+	tests := []struct {
+		name        string
+		fullName    string
+		dateOfBirth string
+		status      string
+	}{
+		{"unchanged", "John Doe", "1980-01-01", ScreeningCleared},
+		{"watchlisted name", "Ivan Petrov", "1980-01-01", ScreeningHeld},
+		{"watchlisted name in other word order", "Petrov, Ivan", "1980-01-01", ScreeningHeld},
+	}
+
+	for _, test := range tests {
+		kycRecord := &KYCRecord{
+			UserID:        "user123",
+			SolanaAddress: "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+			FullName:      test.fullName,
+			DateOfBirth:   test.dateOfBirth,
+		}
+		err := contract.screenKYCRecord(ctx, kycRecord)
+		if err != nil {
+			t.Fatalf("%s: screenKYCRecord failed: %v", test.name, err)
+		}
+		if kycRecord.ScreeningStatus != test.status {
+			t.Errorf("%s: screening status = %q, want %q", test.name, kycRecord.ScreeningStatus, test.status)
+		}
+	}
+}
+
+func TestScreenKYCRecordKeepsClearanceOfUnchangedMatches(t *testing.T) {
+	stub := shimtest.NewMockStub("nivix-kyc", nil)
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	contract := new(SmartContract)
+
+	stub.MockTransactionStart("tx1")
+	putTestWatchlistEntry(t, stub, &WatchlistEntry{ID: "sdn-1", Name: "Ivan Petrov", ListSource: "OFAC"})
+	putTestKYCRecord(t, stub, &KYCRecord{
+		UserID:          "user123",
+		SolanaAddress:   "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+		FullName:        "Ivan Petrov",
+		DateOfBirth:     "1990-05-05",
+		ScreeningStatus: ScreeningCleared,
+		ClearedMatches:  []string{"OFAC/sdn-1"},
+	})
+
+	resubmit := func() string {
+		kycRecord := &KYCRecord{
+			UserID:        "user123",
+			SolanaAddress: "8xj5hKLmrDVXA9VQxwiBrdS9GYmYLJ2GkQrZ7K1i9VJE",
+			FullName:      "Ivan Petrov",
+			DateOfBirth:   "1990-05-05",
+		}
+		err := contract.screenKYCRecord(ctx, kycRecord)
+		if err != nil {
+			t.Fatalf("screenKYCRecord failed: %v", err)
+		}
+		return kycRecord.ScreeningStatus
+	}
+
+	if status := resubmit(); status != ScreeningCleared {
+		t.Fatalf("screening status = %q, want %q", status, ScreeningCleared)
+	}
+
+	// A new watchlist entry matching the customer was never cleared
+	putTestWatchlistEntry(t, stub, &WatchlistEntry{ID: "hmt-7", Name: "Ivan Petrov", ListSource: "HMT"})
+	if status := resubmit(); status != ScreeningHeld {
+		t.Fatalf("screening status after a new match = %q, want %q", status, ScreeningHeld)
+	}
+}